@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// POST /admin/clear-parsed?id=123 — null parser output + denorm columns for a
+// row before reprocessing it.
+func handleClearParsed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdmin(w, r) || !storeAvailable(w) {
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := store.ClearParsedByID(r.Context(), id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("ClearParsedByID err (id=%d): %v", id, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf(`{"event":"admin_clear_parsed","row_id":%d}`, id)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "id": id})
+}
+
+// POST /admin/purge-receipts?older_than=720h — drop orphan idempotency receipts past
+// the retry window.
+func handlePurgeReceipts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdmin(w, r) || !storeAvailable(w) {
+		return
+	}
+	age, err := time.ParseDuration(r.URL.Query().Get("older_than"))
+	if err != nil || age <= 0 {
+		http.Error(w, "bad older_than", http.StatusBadRequest)
+		return
+	}
+	before := time.Now().Add(-age)
+	n, err := store.PurgeOrphanReceipts(r.Context(), before)
+	if err != nil {
+		log.Printf("PurgeOrphanReceipts err (before=%s): %v", before.Format(time.RFC3339), err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf(`{"event":"admin_purge_receipts","before":"%s","deleted":%d}`, before.Format(time.RFC3339), n)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "deleted": n})
+}
+
+// POST /admin/delete-by-mac-prefix?prefix=FFFF00 — remove synthetic load-test
+// frames. The prefix is 1-5 whole bytes of hex so a single real gateway (or
+// the whole table) can't be hit by accident.
+func handleDeleteByMACPrefix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdmin(w, r) || !storeAvailable(w) {
+		return
+	}
+	prefix, err := hex.DecodeString(r.URL.Query().Get("prefix"))
+	if err != nil || len(prefix) == 0 || len(prefix) > 5 {
+		http.Error(w, "bad prefix (expect 1-5 bytes of hex)", http.StatusBadRequest)
+		return
+	}
+	n, err := store.DeleteByMACPrefix(r.Context(), prefix)
+	if err != nil {
+		log.Printf("DeleteByMACPrefix err (prefix=%X): %v", prefix, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf(`{"event":"admin_delete_by_mac_prefix","prefix":"%X","deleted":%d}`, prefix, n)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "deleted": n})
+}
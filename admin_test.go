@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRoutesNeedAdminToken(t *testing.T) {
+	routes := map[string]http.HandlerFunc{
+		"/admin/clear-parsed?id=1":                handleClearParsed,
+		"/admin/purge-receipts?older_than=720h":   handlePurgeReceipts,
+		"/admin/delete-by-mac-prefix?prefix=FFFF": handleDeleteByMACPrefix,
+	}
+	for _, tc := range []struct {
+		name       string
+		admin, tok string
+		want       int
+	}{
+		{"unset refuses even an open service", "", "", http.StatusForbidden},
+		{"unset ignores the ingest token", "", "ingest", http.StatusForbidden},
+		{"missing token", "root", "", http.StatusUnauthorized},
+		{"ingest token is not admin", "root", "ingest", http.StatusUnauthorized},
+		{"admin token passes", "root", "root", http.StatusServiceUnavailable}, // no store in tests
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prevAuth, prevAdmin := authToken, adminToken
+			authToken, adminToken = "", tc.admin
+			t.Cleanup(func() { authToken, adminToken = prevAuth, prevAdmin })
+
+			for url, h := range routes {
+				req := httptest.NewRequest(http.MethodPost, url, nil)
+				if tc.tok != "" {
+					req.Header.Set("Authorization", "Bearer "+tc.tok)
+				}
+				rec := httptest.NewRecorder()
+				h(rec, req)
+				if rec.Code != tc.want {
+					t.Errorf("%s: status %d, want %d", url, rec.Code, tc.want)
+				}
+			}
+		})
+	}
+}
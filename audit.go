@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditLog records one line per ingestion decision, apart from operational
+// logs (AUDIT_LOG: "stdout" for tagged stdout lines, else a file path to
+// append to; unset disables it).
+var auditLog *log.Logger
+
+func initAudit() {
+	switch v := os.Getenv("AUDIT_LOG"); v {
+	case "":
+	case "stdout":
+		auditLog = log.New(os.Stdout, "AUDIT ", 0)
+	default:
+		f, err := os.OpenFile(v, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("AUDIT_LOG: %v", err)
+		}
+		auditLog = log.New(f, "", 0)
+	}
+}
+
+// auditEntry collects what the pipeline learns about a request (or one
+// NDJSON line) for its audit line; the middleware only sees the response.
+type auditEntry struct {
+	mu    sync.Mutex
+	gwMAC string
+	dup   bool
+}
+
+type auditCtxKey struct{}
+
+func withAuditEntry(ctx context.Context) (context.Context, *auditEntry) {
+	e := &auditEntry{}
+	return context.WithValue(ctx, auditCtxKey{}, e), e
+}
+
+// noteAudit records the envelope outcome on the entry in ctx, if any.
+func noteAudit(ctx context.Context, gwMAC string, res ingestResult) {
+	e, _ := ctx.Value(auditCtxKey{}).(*auditEntry)
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.gwMAC, e.dup = gwMAC, res.Body["dup"] == true
+	e.mu.Unlock()
+}
+
+// auditWriter captures the status actually sent, including the 503 that
+// http.TimeoutHandler writes on its own.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *auditWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// withAudit writes one audit line per response of an ingest route, so the
+// early rejections (auth, missing key, bad json, timeouts) are covered too.
+func withAudit(h http.Handler) http.Handler {
+	if auditLog == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, e := withAuditEntry(r.Context())
+		aw := &auditWriter{ResponseWriter: w}
+		h.ServeHTTP(aw, r.WithContext(ctx))
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		e.mu.Lock()
+		rec := auditRecord{Route: r.Pattern, IdemKey: r.Header.Get("X-Idempotency-Key"), GWMAC: e.gwMAC, Status: aw.status, Dup: e.dup}
+		e.mu.Unlock()
+		auditDecision(rec)
+	})
+}
+
+// auditRecord is one audit line; Line is the 1-based NDJSON line, 0 for a
+// whole request.
+type auditRecord struct {
+	Route   string
+	IdemKey string
+	GWMAC   string
+	Status  int
+	Dup     bool
+	Line    int
+}
+
+// auditDecision logs accepted / deduped / rejected for one request or line.
+func auditDecision(rec auditRecord) {
+	if auditLog == nil {
+		return
+	}
+	decision := "accepted"
+	switch {
+	case rec.Dup:
+		decision = "deduped"
+	case rec.Status >= 300:
+		decision = "rejected"
+	}
+	line := map[string]any{
+		"ts":              time.Now().UTC().Format(time.RFC3339Nano),
+		"route":           rec.Route,
+		"idempotency_key": rec.IdemKey,
+		"gw_mac":          rec.GWMAC,
+		"decision":        decision,
+		"status":          rec.Status,
+	}
+	if rec.Line > 0 {
+		line["line"] = rec.Line
+	}
+	b, _ := json.Marshal(line)
+	auditLog.Println(string(b))
+}
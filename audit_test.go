@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureAudit points auditLog at a buffer and returns an ingest mux built
+// with it; the returned func decodes the lines logged so far.
+func captureAudit(t *testing.T) (*http.ServeMux, func() []map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := auditLog
+	auditLog = log.New(&buf, "", 0)
+	t.Cleanup(func() { auditLog = prev })
+	mux := http.NewServeMux()
+	registerIngest(mux)
+	return mux, func() []map[string]any {
+		var out []map[string]any
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if l == "" {
+				continue
+			}
+			var m map[string]any
+			if err := json.Unmarshal([]byte(l), &m); err != nil {
+				t.Fatalf("audit line %q: %v", l, err)
+			}
+			out = append(out, m)
+		}
+		return out
+	}
+}
+
+func TestAuditEveryRejection(t *testing.T) {
+	cases := []struct {
+		name   string
+		setup  func(t *testing.T)
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+	}{
+		{"auto unauthorized", withAuthToken, http.MethodPost, "/auto", "k1", `{}`, http.StatusUnauthorized},
+		{"auto forbidden", withAllowedNet, http.MethodPost, "/auto", "k1", `{}`, http.StatusForbidden},
+		{"auto missing key", nil, http.MethodPost, "/auto", "", `{}`, http.StatusBadRequest},
+		{"auto bad json", nil, http.MethodPost, "/auto", "k1", `{`, http.StatusBadRequest},
+		{"auto wrong method", nil, http.MethodGet, "/auto", "k1", ``, http.StatusMethodNotAllowed},
+		{"path unauthorized", withAuthToken, http.MethodPost, "/auto/MKGW4/CCE01BA20624", "k1", `00`, http.StatusUnauthorized},
+		{"path missing key", nil, http.MethodPost, "/auto/MKGW4/CCE01BA20624", "", `00`, http.StatusBadRequest},
+		{"path bad mac", nil, http.MethodPost, "/auto/MKGW4/XYZ", "k1", `00`, http.StatusBadRequest},
+		{"path bad device ts", nil, http.MethodPost, "/auto/MKGW4/CCE01BA20624?device_ts_ms=x", "k1", `00`, http.StatusBadRequest},
+		{"ndjson unauthorized", withAuthToken, http.MethodPost, "/auto/ndjson", "k1", `{}`, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup(t)
+			}
+			mux, lines := captureAudit(t)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.key != "" {
+				req.Header.Set("X-Idempotency-Key", tc.key)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.status, w.Body.String())
+			}
+			got := lines()
+			if len(got) != 1 {
+				t.Fatalf("%d audit lines, want 1: %v", len(got), got)
+			}
+			if got[0]["decision"] != "rejected" || got[0]["status"] != float64(tc.status) || got[0]["idempotency_key"] != tc.key {
+				t.Errorf("audit %v", got[0])
+			}
+		})
+	}
+}
+
+func TestAuditTimeout(t *testing.T) {
+	prev := requestTimeout
+	requestTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = prev })
+	mux, lines := captureAudit(t)
+
+	// The body never arrives, so handleAuto is still decoding at the deadline.
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+	req := httptest.NewRequest(http.MethodPost, "/auto", pr)
+	req.Header.Set("X-Idempotency-Key", "slow")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", w.Code)
+	}
+	got := lines()
+	if len(got) != 1 || got[0]["status"] != float64(http.StatusServiceUnavailable) || got[0]["decision"] != "rejected" {
+		t.Errorf("audit %v", got)
+	}
+}
+
+func TestAuditNDJSONLines(t *testing.T) {
+	mux, lines := captureAudit(t)
+	body := strings.Join([]string{
+		`{`,
+		`{"gw_hw":"MKGW4","gw_mac":"cce01ba20624","payload_hex":"00"}`,
+		`{"idempotency_key":"k3","gw_hw":"MKGW4","payload_hex":"00"}`,
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/auto/ndjson", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+
+	got := lines()
+	if len(got) != 4 {
+		t.Fatalf("%d audit lines, want 3 lines + the request: %v", len(got), got)
+	}
+	want := []struct {
+		line   float64
+		key    string
+		gwMAC  string
+		status float64
+	}{
+		{1, "", "", http.StatusBadRequest},             // bad json
+		{2, "", "CCE01BA20624", http.StatusBadRequest}, // missing idempotency
+		{3, "k3", "", http.StatusBadRequest},           // missing gw_mac, via processEnvelope
+	}
+	for i, wl := range want {
+		g := got[i]
+		if g["line"] != wl.line || g["idempotency_key"] != wl.key || g["gw_mac"] != wl.gwMAC || g["status"] != wl.status || g["decision"] != "rejected" {
+			t.Errorf("line %d audit %v", i+1, g)
+		}
+	}
+	if req := got[3]; req["line"] != nil || req["status"] != float64(http.StatusOK) || req["route"] != "POST /auto/ndjson" {
+		t.Errorf("request audit %v", req)
+	}
+}
+
+func withAuthToken(t *testing.T) {
+	prev := authToken
+	authToken = "secret"
+	t.Cleanup(func() { authToken = prev })
+}
+
+func withAllowedNet(t *testing.T) {
+	prev := allowedNet
+	allowedNet = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")} // httptest requests come from 192.0.2.1
+	t.Cleanup(func() { allowedNet = prev })
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	pubsub "cloud.google.com/go/pubsub"
+)
+
+var (
+	sourceName      = "ble-gw-auto-parser" // SOURCE_NAME, e.g. "ble-gw-auto-parser-staging"
+	parserVersion   string                 // PARSER_VERSION, e.g. "v3" -> "mkgw4:auto@v3"
+	parserOverrides map[string]string      // PARSER_NAMES, e.g. "MKGW4=mkgw4:auto@v4-rc"
+	epochOffsets    map[string]int64       // EPOCH_OFFSETS, e.g. "MKGW4=946684800" (2000-01-01 epoch)
+	defaultFlags    map[string]string      // DEFAULT_FLAGS, e.g. "MKGW4=3004": flag assumed when none is known
+
+	contentHashEnabled bool                   // CONTENT_HASH=1: parsed["content_hash"] + Pub/Sub attribute
+	requireDeviceTs    bool                   // REQUIRE_DEVICE_TS=1: 400 instead of defaulting to epoch
+	keepEmptyFlag      bool                   // EMPTY_FLAG=keep: store "" instead of "json" when no flag is known
+	idemPerMAC         bool                   // IDEMPOTENCY_SCOPE=gw_mac: dedupe on (gw_mac, key) instead of key alone
+	jsonFloatNumbers   bool                   // JSON_NUMBERS=float: JSON gateway integers via float64 (legacy) instead of exact
+	jsonPayloadCheck   string                 // JSON_PAYLOAD_CHECK: "" (off), "flag" or "reject" bad JSON gateway bodies
+	pubsubCompress     bool                   // PUBSUB_COMPRESS=1: gzip published data, content-encoding attribute
+	maxPayloadHex      = defaultMaxPayloadHex // MAX_PAYLOAD_HEX: payload_hex length cap, checked before decode
+	requestTimeout     = 30 * time.Second     // REQUEST_TIMEOUT: overall deadline for /auto requests
+	ndjsonTimeout      = 2 * time.Minute      // NDJSON_TIMEOUT: overall deadline for /auto/ndjson streams
+	rawOnlyFlags       []string               // RAW_ONLY_FLAGS, e.g. "30A0,30D0": write parser_json only, skip denorm columns
+	macLengths         = []int{12}            // MAC_LENGTHS, e.g. "12,16" to accept 16-char extended ids
+	geohashPrecision   = 9                    // GEOHASH_PRECISION: fix.geohash / geohash column length (1-12)
+	insertMode         bool                   // INSERT_MODE=1: envelopes without row_id become new gateway_message rows
+)
+
+const defaultMaxPayloadHex = 16 << 10
+
+// loadConfig reads the optional tuning envs into package state. Bad values
+// are fatal at startup rather than silently ignored.
+func loadConfig() {
+	decodeOpts.BCDIdentifiers = envBool("DECODE_BCD_IDS", true)
+	decodeOpts.MaxTLVEntries = envInt("MAX_TLV_ENTRIES", defaultMaxTLVEntries)
+	if v := os.Getenv("TAG_OFFSET"); v != "" { // e.g. "0x80"
+		n, err := strconv.ParseUint(v, 0, 8)
+		if err != nil {
+			log.Fatalf("bad TAG_OFFSET %q", v)
+		}
+		decodeOpts.TagOffset = byte(n)
+	}
+	decodeOpts.KeepRawTags = envBool("DECODE_KEEP_RAW_TLVS", false)
+	decodeOpts.StrictFrameLen = envBool("STRICT_FRAME_LEN", false)
+	decodeOpts.SanitizeASCII = envBool("SANITIZE_ASCII", true)
+	if os.Getenv("LENGTH_PREFIX_BYTES") != "" {
+		decodeOpts.LengthPrefix = envInt("LENGTH_PREFIX_BYTES", 0)
+		if decodeOpts.LengthPrefix > 4 {
+			log.Fatalf("bad LENGTH_PREFIX_BYTES %d (expect 1-4)", decodeOpts.LengthPrefix)
+		}
+	}
+	if v := os.Getenv("DECODE_CACHE_SIZE"); v != "" {
+		decodes = newDecodeCache(envInt("DECODE_CACHE_SIZE", 0), envDuration("DECODE_CACHE_TTL", 30*time.Second))
+	}
+	switch v := os.Getenv("TLV_LEN_MODE"); v {
+	case "", "value":
+		decodeOpts.TLVLength = TLVLenValue
+	case "inclusive":
+		decodeOpts.TLVLength = TLVLenInclusive
+	case "auto":
+		decodeOpts.TLVLength = TLVLenAuto
+	default:
+		log.Fatalf("bad TLV_LEN_MODE %q (expect value, inclusive or auto)", v)
+	}
+	decodeOpts.MinLat = envFloat("GEOFENCE_MIN_LAT", -90)
+	decodeOpts.MaxLat = envFloat("GEOFENCE_MAX_LAT", 90)
+	decodeOpts.MinLon = envFloat("GEOFENCE_MIN_LON", -180)
+	decodeOpts.MaxLon = envFloat("GEOFENCE_MAX_LON", 180)
+
+	switch v := os.Getenv("DUP_STATUS"); v {
+	case "", "200":
+		dupStatus = http.StatusOK
+	case "409":
+		dupStatus = http.StatusConflict
+	default:
+		log.Fatalf("bad DUP_STATUS %q (expect 200 or 409)", v)
+	}
+
+	contentHashEnabled = envBool("CONTENT_HASH", false)
+	requireDeviceTs = envBool("REQUIRE_DEVICE_TS", false)
+	insertMode = envBool("INSERT_MODE", false)
+	switch v := os.Getenv("JSON_PAYLOAD_CHECK"); v {
+	case "", "off":
+		jsonPayloadCheck = ""
+	case "flag", "reject":
+		jsonPayloadCheck = v
+	default:
+		log.Fatalf("bad JSON_PAYLOAD_CHECK %q (expect off, flag or reject)", v)
+	}
+	switch v := os.Getenv("EMPTY_FLAG"); v {
+	case "", "json":
+		keepEmptyFlag = false
+	case "keep":
+		keepEmptyFlag = true
+	default:
+		log.Fatalf("bad EMPTY_FLAG %q (expect json or keep)", v)
+	}
+	switch v := os.Getenv("IDEMPOTENCY_SCOPE"); v {
+	case "", "global":
+		idemPerMAC = false
+	case "gw_mac":
+		idemPerMAC = true
+	default:
+		log.Fatalf("bad IDEMPOTENCY_SCOPE %q (expect global or gw_mac)", v)
+	}
+	switch v := os.Getenv("JSON_NUMBERS"); v {
+	case "", "exact":
+		jsonFloatNumbers = false
+	case "float":
+		jsonFloatNumbers = true
+	default:
+		log.Fatalf("bad JSON_NUMBERS %q (expect exact or float)", v)
+	}
+	pubsubCompress = envBool("PUBSUB_COMPRESS", false)
+	maxPayloadHex = envInt("MAX_PAYLOAD_HEX", defaultMaxPayloadHex)
+	requestTimeout = envDuration("REQUEST_TIMEOUT", requestTimeout)
+	ndjsonTimeout = envDuration("NDJSON_TIMEOUT", ndjsonTimeout)
+	if geohashPrecision = envInt("GEOHASH_PRECISION", 9); geohashPrecision > 12 {
+		log.Fatalf("bad GEOHASH_PRECISION %d (expect 1-12)", geohashPrecision)
+	}
+	if v := os.Getenv("SOURCE_NAME"); v != "" {
+		sourceName = v
+	}
+	parserVersion = os.Getenv("PARSER_VERSION")
+	parserOverrides = envMap("PARSER_NAMES")
+	defaultFlags = map[string]string{}
+	for hw, v := range envMap("DEFAULT_FLAGS") {
+		v = strings.ToUpper(strings.TrimPrefix(strings.ToLower(v), "self/"))
+		if len(v) != 4 || !onlyHex(v) {
+			log.Fatalf("bad DEFAULT_FLAGS entry %s=%q (expect a 4-hex-digit flag)", hw, v)
+		}
+		defaultFlags[hw] = v
+	}
+	epochOffsets = map[string]int64{}
+	for hw, v := range envMap("EPOCH_OFFSETS") {
+		off, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("bad EPOCH_OFFSETS entry %s=%q", hw, v)
+		}
+		epochOffsets[hw] = off
+	}
+
+	if v := os.Getenv("RAW_ONLY_FLAGS"); v != "" { // e.g. "30A0,self/30D0"
+		rawOnlyFlags = nil
+		for _, f := range strings.Split(v, ",") {
+			if bareFlag(f) == "" {
+				log.Fatalf("bad RAW_ONLY_FLAGS entry %q", f)
+			}
+			rawOnlyFlags = append(rawOnlyFlags, bareFlag(f))
+		}
+	}
+	if v := os.Getenv("MAC_LENGTHS"); v != "" { // e.g. "12,16"
+		macLengths = nil
+		for _, s := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || (n != 12 && n != 16) {
+				log.Fatalf("bad MAC_LENGTHS entry %q (expect 12 or 16)", s)
+			}
+			macLengths = append(macLengths, n)
+		}
+	}
+	if v := os.Getenv("HEADER_MAGIC"); v != "" { // e.g. "EF,EE,FE"
+		headerMagics = nil
+		for _, mb := range strings.Split(v, ",") {
+			mb = strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(mb), "0x"))
+			if len(mb) != 2 || !onlyHex(mb) {
+				log.Fatalf("bad HEADER_MAGIC entry %q (expect one hex byte)", mb)
+			}
+			headerMagics = append(headerMagics, mb)
+		}
+	}
+
+	// e.g. "34.0.0.0/16,35.1.2.3" (our gateway egress ranges)
+	if v := os.Getenv("ALLOWED_SOURCE_CIDRS"); v != "" {
+		var err error
+		allowedNet, err = parseCIDRList(v)
+		if err != nil {
+			log.Fatalf("ALLOWED_SOURCE_CIDRS: %v", err)
+		}
+	}
+}
+
+// publishSettings starts from the library defaults and applies the batching
+// envs (PUBSUB_BATCH_DELAY/COUNT/BYTES, PUBSUB_PUBLISH_TIMEOUT). A
+// PUBSUB_FLOW_MAX_MESSAGES cap makes Publish block once reached; the default
+// is to ignore the limit.
+func publishSettings() pubsub.PublishSettings {
+	ps := pubsub.DefaultPublishSettings
+	ps.DelayThreshold = envDuration("PUBSUB_BATCH_DELAY", ps.DelayThreshold)
+	ps.CountThreshold = envInt("PUBSUB_BATCH_COUNT", ps.CountThreshold)
+	ps.ByteThreshold = envInt("PUBSUB_BATCH_BYTES", ps.ByteThreshold)
+	ps.Timeout = envDuration("PUBSUB_PUBLISH_TIMEOUT", ps.Timeout)
+	if os.Getenv("PUBSUB_FLOW_MAX_MESSAGES") != "" {
+		ps.FlowControlSettings.MaxOutstandingMessages = envInt("PUBSUB_FLOW_MAX_MESSAGES", 0)
+		ps.FlowControlSettings.LimitExceededBehavior = pubsub.FlowControlBlock
+	}
+	return ps
+}
+
+// parserNameFor returns the parser name recorded on the row for gw_hw: an
+// explicit PARSER_NAMES override wins, else the default name + @version.
+func parserNameFor(gwHW string) string {
+	if name, ok := parserOverrides[gwHW]; ok {
+		return name
+	}
+	name := "gw_json:auto"
+	if gwHW == "MKGW4" {
+		name = "mkgw4:auto"
+	}
+	if parserVersion != "" {
+		name += "@" + parserVersion
+	}
+	return name
+}
+
+// envMap parses "K1=v1,K2=v2"; keys are uppercased (gw_hw style).
+func envMap(k string) map[string]string {
+	out := map[string]string{}
+	v := os.Getenv(k)
+	if v == "" {
+		return out
+	}
+	for _, kv := range strings.Split(v, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			log.Fatalf("bad %s entry %q (expect KEY=value)", k, kv)
+		}
+		out[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(val)
+	}
+	return out
+}
+
+// metadataTimeout keeps local runs (no metadata server) failing fast.
+const metadataTimeout = 2 * time.Second
+
+// resolveProjectID prefers PROJECT_ID, then GOOGLE_CLOUD_PROJECT, then the
+// GCE/Cloud Run metadata server. Returns "" when none is available.
+func resolveProjectID() string {
+	for _, k := range []string{"PROJECT_ID", "GOOGLE_CLOUD_PROJECT"} {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+	id, err := metadata.ProjectIDWithContext(ctx)
+	if err != nil {
+		log.Printf("project id from metadata server: %v", err)
+		return ""
+	}
+	log.Printf("project id from metadata server: %s", id)
+	return id
+}
+
+func envBool(k string, def bool) bool {
+	switch os.Getenv(k) {
+	case "":
+		return def
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	default:
+		log.Fatalf("bad %s %q (expect 0/1)", k, os.Getenv(k))
+		return def
+	}
+}
+
+func envInt(k string, def int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("bad %s %q", k, v)
+	}
+	return n
+}
+
+func envFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("bad %s %q", k, v)
+	}
+	return f
+}
+
+func envDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("bad %s %q (expect e.g. 30s)", k, v)
+	}
+	return d
+}
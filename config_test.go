@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keepConfig restores the package state loadConfig writes.
+func keepConfig(t *testing.T) {
+	t.Helper()
+	opts, cache, dup := decodeOpts, decodes, dupStatus
+	hash, reqTs, ins, jsonCheck, keepEmpty, perMAC, gz, floatNums := contentHashEnabled, requireDeviceTs, insertMode, jsonPayloadCheck, keepEmptyFlag, idemPerMAC, pubsubCompress, jsonFloatNumbers
+	maxHex, timeout, ndTimeout, ghPrec, src, ver := maxPayloadHex, requestTimeout, ndjsonTimeout, geohashPrecision, sourceName, parserVersion
+	overrides, defFlags, offsets, rawOnly, macs, magics, nets := parserOverrides, defaultFlags, epochOffsets, rawOnlyFlags, macLengths, headerMagics, allowedNet
+	t.Cleanup(func() {
+		decodeOpts, decodes, dupStatus = opts, cache, dup
+		contentHashEnabled, requireDeviceTs, insertMode, jsonPayloadCheck, keepEmptyFlag, idemPerMAC, pubsubCompress, jsonFloatNumbers = hash, reqTs, ins, jsonCheck, keepEmpty, perMAC, gz, floatNums
+		maxPayloadHex, requestTimeout, ndjsonTimeout, geohashPrecision, sourceName, parserVersion = maxHex, timeout, ndTimeout, ghPrec, src, ver
+		parserOverrides, defaultFlags, epochOffsets, rawOnlyFlags, macLengths, headerMagics, allowedNet = overrides, defFlags, offsets, rawOnly, macs, magics, nets
+	})
+}
+
+func TestEnvHelpers(t *testing.T) {
+	t.Setenv("T_BOOL_1", "1")
+	t.Setenv("T_BOOL_F", "false")
+	t.Setenv("T_INT", "42")
+	t.Setenv("T_FLOAT", "-12.5")
+	t.Setenv("T_DUR", "1500ms")
+	t.Setenv("T_MAP", " mkgw4 = 3004 , MKGWMINI01=x=y")
+
+	if !envBool("T_BOOL_1", false) || envBool("T_BOOL_F", true) || !envBool("T_UNSET", true) {
+		t.Error("envBool")
+	}
+	if envInt("T_INT", 1) != 42 || envInt("T_UNSET", 7) != 7 {
+		t.Error("envInt")
+	}
+	if envFloat("T_FLOAT", 0) != -12.5 || envFloat("T_UNSET", 3) != 3 {
+		t.Error("envFloat")
+	}
+	if envDuration("T_DUR", 0) != 1500*time.Millisecond || envDuration("T_UNSET", time.Second) != time.Second {
+		t.Error("envDuration")
+	}
+	m := envMap("T_MAP")
+	if len(m) != 2 || m["MKGW4"] != "3004" || m["MKGWMINI01"] != "x=y" {
+		t.Errorf("envMap = %v", m)
+	}
+	if m := envMap("T_UNSET"); m == nil || len(m) != 0 {
+		t.Errorf("envMap(unset) = %v", m)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	keepConfig(t)
+	for k, v := range map[string]string{
+		"TAG_OFFSET":           "0x80",
+		"TLV_LEN_MODE":         "auto",
+		"DUP_STATUS":           "409",
+		"INSERT_MODE":          "1",
+		"IDEMPOTENCY_SCOPE":    "gw_mac",
+		"JSON_NUMBERS":         "float",
+		"JSON_PAYLOAD_CHECK":   "reject",
+		"REQUEST_TIMEOUT":      "5s",
+		"NDJSON_TIMEOUT":       "1m",
+		"GEOHASH_PRECISION":    "7",
+		"PARSER_VERSION":       "v3",
+		"PARSER_NAMES":         "MKGWMINI01=mini:v9",
+		"DEFAULT_FLAGS":        "mkgw4=self/30a0",
+		"EPOCH_OFFSETS":        "MKGW4=946684800",
+		"RAW_ONLY_FLAGS":       "30a0, self/30D0",
+		"MAC_LENGTHS":          "12,16",
+		"HEADER_MAGIC":         "0xEF,ee",
+		"ALLOWED_SOURCE_CIDRS": "10.0.0.0/8,192.0.2.7",
+		"DECODE_CACHE_SIZE":    "64",
+	} {
+		t.Setenv(k, v)
+	}
+	loadConfig()
+
+	switch {
+	case decodeOpts.TagOffset != 0x80 || decodeOpts.TLVLength != TLVLenAuto:
+		t.Errorf("decodeOpts %+v", decodeOpts)
+	case dupStatus != 409 || !insertMode || !idemPerMAC || jsonPayloadCheck != "reject" || !jsonFloatNumbers:
+		t.Errorf("dup %d insert %v perMAC %v check %q float %v", dupStatus, insertMode, idemPerMAC, jsonPayloadCheck, jsonFloatNumbers)
+	case requestTimeout != 5*time.Second || ndjsonTimeout != time.Minute || geohashPrecision != 7:
+		t.Errorf("timeout %v ndjson %v geohash %d", requestTimeout, ndjsonTimeout, geohashPrecision)
+	case parserNameFor("MKGW4") != "mkgw4:auto@v3" || parserNameFor("MKGWMINI01") != "mini:v9" || parserNameFor("MKGW3") != "gw_json:auto@v3":
+		t.Errorf("parser names %q %q %q", parserNameFor("MKGW4"), parserNameFor("MKGWMINI01"), parserNameFor("MKGW3"))
+	case defaultFlags["MKGW4"] != "30A0" || epochOffsets["MKGW4"] != 946684800:
+		t.Errorf("default flags %v offsets %v", defaultFlags, epochOffsets)
+	case !slices.Equal(rawOnlyFlags, []string{"30A0", "30D0"}) || !slices.Equal(macLengths, []int{12, 16}) || !slices.Equal(headerMagics, []string{"EF", "EE"}):
+		t.Errorf("raw only %v macs %v magics %v", rawOnlyFlags, macLengths, headerMagics)
+	case len(allowedNet) != 2 || allowedNet[1].String() != "192.0.2.7/32":
+		t.Errorf("allowed %v", allowedNet)
+	case decodes == nil || decodes.max != 64:
+		t.Errorf("decode cache %+v", decodes)
+	}
+}
+
+// TestLoadConfigRejects runs loadConfig in a child process per bad value,
+// since a bad value is fatal.
+func TestLoadConfigRejects(t *testing.T) {
+	if kv := os.Getenv("LOADCONFIG_CHILD"); kv != "" {
+		k, v, _ := strings.Cut(kv, "=")
+		os.Setenv(k, v)
+		loadConfig()
+		os.Exit(0)
+	}
+	for _, kv := range []string{
+		"TAG_OFFSET=0x100",
+		"LENGTH_PREFIX_BYTES=5",
+		"TLV_LEN_MODE=both",
+		"DUP_STATUS=204",
+		"INSERT_MODE=yes",
+		"IDEMPOTENCY_SCOPE=tenant",
+		"JSON_NUMBERS=decimal",
+		"MAX_PAYLOAD_HEX=-1",
+		"REQUEST_TIMEOUT=5",
+		"GEOHASH_PRECISION=13",
+		"DEFAULT_FLAGS=MKGW4=30",
+		"PARSER_NAMES=novalue",
+		"EPOCH_OFFSETS=MKGW4=x",
+		"MAC_LENGTHS=14",
+		"HEADER_MAGIC=EFEF",
+		"ALLOWED_SOURCE_CIDRS=not-a-net",
+		"GEOFENCE_MIN_LAT=north",
+	} {
+		t.Run(kv, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestLoadConfigRejects$")
+			cmd.Env = append(os.Environ(), "LOADCONFIG_CHILD="+kv)
+			if out, err := cmd.CombinedOutput(); err == nil {
+				t.Errorf("accepted; output:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestParseCIDRList(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"10.0.0.0/8", []string{"10.0.0.0/8"}, false},
+		{" 10.1.2.3 , 2001:db8::/32", []string{"10.1.2.3/32", "2001:db8::/32"}, false},
+		{"10.0.0.0/33", nil, true},
+		{"example.com", nil, true},
+	}
+	for _, tc := range cases {
+		got, err := parseCIDRList(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseCIDRList(%q) err = %v", tc.in, err)
+			continue
+		}
+		var s []string
+		for _, p := range got {
+			s = append(s, p.String())
+		}
+		if !slices.Equal(s, tc.want) {
+			t.Errorf("parseCIDRList(%q) = %v, want %v", tc.in, s, tc.want)
+		}
+	}
+}
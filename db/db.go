@@ -14,14 +14,20 @@ import (
 
 var Pool *pgxpool.Pool
 
+// ReadPool points at the read replica when INSTANCE_CONNECTION_NAME_REPLICA
+// is set; otherwise it is Pool itself.
+var ReadPool *pgxpool.Pool
+
 // Connect initializes the global pgx Pool using the Cloud SQL Go connector.
 // Required envs: DB_USER, DB_PASSWORD, DB_NAME, INSTANCE_CONNECTION_NAME
-// Optional: PRIVATE_IP (any non-empty value enables Private IP)
+// Optional: PRIVATE_IP (any non-empty value enables Private IP),
+// INSTANCE_CONNECTION_NAME_REPLICA (read replica for query endpoints)
 func Connect() error {
 	dbUser := os.Getenv("DB_USER")
 	dbPass := os.Getenv("DB_PASSWORD")
 	dbName := os.Getenv("DB_NAME")
 	instance := os.Getenv("INSTANCE_CONNECTION_NAME")
+	replica := os.Getenv("INSTANCE_CONNECTION_NAME_REPLICA")
 	usePrivate := os.Getenv("PRIVATE_IP") != ""
 
 	if dbUser == "" || dbPass == "" || dbName == "" || instance == "" {
@@ -40,9 +46,26 @@ func Connect() error {
 		return fmt.Errorf("cloudsql dialer: %w", err)
 	}
 
+	if Pool, err = newPool(dsn, dialer, instance); err != nil {
+		return err
+	}
+	log.Println("CONNECTED TO DATABASE")
+
+	ReadPool = Pool
+	if replica != "" {
+		if ReadPool, err = newPool(dsn, dialer, replica); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+		log.Println("CONNECTED TO READ REPLICA")
+	}
+	return nil
+}
+
+// newPool opens and pings a pool dialing the given Cloud SQL instance.
+func newPool(dsn string, dialer *cloudsqlconn.Dialer, instance string) (*pgxpool.Pool, error) {
 	cfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		return fmt.Errorf("pgxpool.ParseConfig: %w", err)
+		return nil, fmt.Errorf("pgxpool.ParseConfig: %w", err)
 	}
 
 	// Replace net dialer with Cloud SQL connector dialer
@@ -55,13 +78,13 @@ func Connect() error {
 	cfg.MaxConns = 10
 	cfg.MaxConnIdleTime = 5 * time.Minute
 
-	Pool, err = pgxpool.NewWithConfig(context.Background(), cfg)
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
 	if err != nil {
-		return fmt.Errorf("pgxpool.NewWithConfig: %w", err)
+		return nil, fmt.Errorf("pgxpool.NewWithConfig: %w", err)
 	}
-	if err := Pool.Ping(context.Background()); err != nil {
-		return fmt.Errorf("db ping: %w", err)
+	if err := pool.Ping(context.Background()); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("db ping: %w", err)
 	}
-	log.Println("CONNECTED TO DATABASE")
-	return nil
+	return pool, nil
 }
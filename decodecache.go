@@ -0,0 +1,139 @@
+package main
+
+import (
+	"container/list"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// decodeCache is a bounded LRU of successful MKGW4 decodes keyed by
+// (flag, payload hex), so retry storms skip the TLV walk. Entries expire
+// after ttl. Values are cloned on the way in and out: callers never share
+// an *Auto with the cache or with each other.
+type decodeCache struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	order   *list.List // front = most recent; values are *decodeCacheEntry
+	entries map[decodeCacheKey]*list.Element
+}
+
+type decodeCacheKey struct{ flag, hex string }
+
+type decodeCacheEntry struct {
+	key     decodeCacheKey
+	auto    *Auto
+	expires time.Time
+}
+
+// decodes is nil unless DECODE_CACHE_SIZE is set.
+var decodes *decodeCache
+
+func newDecodeCache(max int, ttl time.Duration) *decodeCache {
+	return &decodeCache{max: max, ttl: ttl, order: list.New(), entries: map[decodeCacheKey]*list.Element{}}
+}
+
+func (c *decodeCache) get(flag, hex string) (*Auto, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[decodeCacheKey{flag, hex}]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*decodeCacheEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, e.key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return cloneAuto(e.auto), true
+}
+
+func (c *decodeCache) put(flag, hex string, a *Auto) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := decodeCacheKey{flag, hex}
+	e := &decodeCacheEntry{key: k, auto: cloneAuto(a), expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[k]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.order.PushFront(e)
+	if c.order.Len() > c.max {
+		old := c.order.Back()
+		c.order.Remove(old)
+		delete(c.entries, old.Value.(*decodeCacheEntry).key)
+	}
+}
+
+// decodeMKGW4Cached is DecodeMKGW4Auto behind the decode cache. Only clean,
+// recognized decodes are cached; errors always re-run the parser. A hit on
+// a frame without its own timestamp gets a fresh fallback, as a re-parse
+// would.
+func decodeMKGW4Cached(flagHex, bodyHex string) (*Auto, bool, error) {
+	if decodes == nil {
+		return DecodeMKGW4Auto(flagHex, bodyHex)
+	}
+	if a, ok := decodes.get(flagHex, bodyHex); ok {
+		if !a.HasFrameTs {
+			a.Timestamp = time.Now().Unix()
+		}
+		return a, true, nil
+	}
+	a, ok, err := DecodeMKGW4Auto(flagHex, bodyHex)
+	if ok && err == nil && a != nil {
+		decodes.put(flagHex, bodyHex, a)
+	}
+	return a, ok, err
+}
+
+// cloneAuto deep-copies everything reachable from a.
+func cloneAuto(a *Auto) *Auto {
+	c := *a
+	c.Warnings = slices.Clone(a.Warnings)
+	c.RawTLVs = maps.Clone(a.RawTLVs)
+	if a.Status != nil {
+		st := *a.Status
+		st.Present = slices.Clone(st.Present)
+		c.Status = &st
+	}
+	if a.Fix != nil {
+		f := *a.Fix
+		f.NeighborCells = slices.Clone(f.NeighborCells)
+		f.Constellations = slices.Clone(f.Constellations)
+		f.Present = slices.Clone(f.Present)
+		c.Fix = &f
+	}
+	if a.Scan != nil {
+		c.Scan = slices.Clone(a.Scan)
+		for i := range c.Scan {
+			c.Scan[i].Sensor = maps.Clone(c.Scan[i].Sensor)
+		}
+	}
+	if a.Sensors != nil {
+		c.Sensors = slices.Clone(a.Sensors)
+		for i := range c.Sensors {
+			c.Sensors[i].Values = maps.Clone(c.Sensors[i].Values)
+			c.Sensors[i].Raw = maps.Clone(c.Sensors[i].Raw)
+		}
+	}
+	if a.StatusHistory != nil {
+		c.StatusHistory = slices.Clone(a.StatusHistory)
+		for i, snap := range c.StatusHistory {
+			st := *snap.Status
+			st.Present = slices.Clone(st.Present)
+			c.StatusHistory[i].Status = &st
+		}
+	}
+	if a.OTAProgress != nil {
+		p := *a.OTAProgress
+		c.OTAProgress = &p
+	}
+	return &c
+}
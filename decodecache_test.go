@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withDecodeCache swaps in a fresh cache for the test.
+func withDecodeCache(t testing.TB, max int, ttl time.Duration) *decodeCache {
+	t.Helper()
+	prev := decodes
+	decodes = newDecodeCache(max, ttl)
+	t.Cleanup(func() { decodes = prev })
+	return decodes
+}
+
+func TestDecodeCacheHitMatchesFreshDecode(t *testing.T) {
+	withDecodeCache(t, 16, time.Minute)
+	for _, g := range goldenFrames {
+		fresh, _, err := DecodeMKGW4Auto(g.Flag, g.Hex)
+		if err != nil {
+			t.Fatalf("flag %s: %v", g.Flag, err)
+		}
+		if _, _, err := decodeMKGW4Cached(g.Flag, g.Hex); err != nil { // fills
+			t.Fatalf("flag %s: %v", g.Flag, err)
+		}
+		hit, ok, err := decodeMKGW4Cached(g.Flag, g.Hex)
+		if !ok || err != nil {
+			t.Fatalf("flag %s: ok=%v err=%v", g.Flag, ok, err)
+		}
+		if !reflect.DeepEqual(hit, fresh) {
+			t.Errorf("flag %s: cached %+v, fresh %+v", g.Flag, hit, fresh)
+		}
+	}
+}
+
+func TestDecodeCacheIsolatesCallers(t *testing.T) {
+	withDecodeCache(t, 16, time.Minute)
+	g := goldenFrames[0]
+	a, _, err := decodeMKGW4Cached(g.Flag, g.Hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Status.CSQ = -1
+	a.Warnings = append(a.Warnings, "mutated")
+	b, _, _ := decodeMKGW4Cached(g.Flag, g.Hex)
+	b.Status.Present = append(b.Status.Present, "mutated")
+
+	c, _, _ := decodeMKGW4Cached(g.Flag, g.Hex)
+	fresh, _, _ := DecodeMKGW4Auto(g.Flag, g.Hex)
+	if !reflect.DeepEqual(c, fresh) {
+		t.Errorf("cache entry changed by a caller: %+v", c)
+	}
+}
+
+func TestDecodeCacheRestampsFallbackTs(t *testing.T) {
+	cache := withDecodeCache(t, 16, time.Minute)
+	cache.put("3004", "NOTS", &Auto{Timestamp: 1})
+	cache.put("3004", "TS", &Auto{Timestamp: 1, HasFrameTs: true})
+
+	before := time.Now().Unix()
+	a, _, _ := decodeMKGW4Cached("3004", "NOTS")
+	if a.Timestamp < before {
+		t.Errorf("fallback ts %d on a hit, want >= %d", a.Timestamp, before)
+	}
+	if a, _, _ := decodeMKGW4Cached("3004", "TS"); a.Timestamp != 1 {
+		t.Errorf("frame ts %d on a hit, want 1", a.Timestamp)
+	}
+}
+
+func TestDecodeCacheEvictsLRU(t *testing.T) {
+	c := newDecodeCache(2, time.Minute)
+	c.put("3004", "A", &Auto{})
+	c.put("3004", "B", &Auto{})
+	c.get("3004", "A") // B is now least recent
+	c.put("3004", "C", &Auto{})
+	for hex, want := range map[string]bool{"A": true, "B": false, "C": true} {
+		if _, ok := c.get("3004", hex); ok != want {
+			t.Errorf("%s cached = %v, want %v", hex, ok, want)
+		}
+	}
+}
+
+func TestDecodeCacheExpires(t *testing.T) {
+	c := newDecodeCache(2, -time.Second)
+	c.put("3004", "A", &Auto{})
+	if _, ok := c.get("3004", "A"); ok {
+		t.Error("expired entry returned")
+	}
+	if c.order.Len() != 0 {
+		t.Errorf("expired entry kept, len %d", c.order.Len())
+	}
+}
+
+func TestDecodeCacheSkipsErrors(t *testing.T) {
+	cache := withDecodeCache(t, 16, time.Minute)
+	if _, _, err := decodeMKGW4Cached("3004", "ZZ"); err == nil {
+		t.Fatal("expected a decode error")
+	}
+	if cache.order.Len() != 0 {
+		t.Errorf("error decode cached (%d entries)", cache.order.Len())
+	}
+}
+
+func benchmarkDecode(b *testing.B, cached bool) {
+	withDecodeCache(b, 1024, time.Hour)
+	if !cached {
+		decodes = nil // restored by withDecodeCache's cleanup
+	}
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		g := goldenFrames[i%len(goldenFrames)]
+		if _, _, err := decodeMKGW4Cached(g.Flag, g.Hex); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeUncached(b *testing.B) { benchmarkDecode(b, false) }
+func BenchmarkDecodeCached(b *testing.B)   { benchmarkDecode(b, true) }
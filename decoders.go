@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Decoded is what a gateway decoder hands back to the ingest pipeline.
+type Decoded struct {
+	Auto    *Auto  // nil when nothing was recognized (raw-only storage)
+	Payload string // normalized payload to store/publish
+	Flag    string // flag to store when the envelope has none
+	Err     error  // non-fatal decode problem, logged
+	Invalid string // JSON gateways: why the body is not valid UTF-8 JSON ("" if it is)
+
+	FlagAssumed bool // Flag came from DEFAULT_FLAGS, not the envelope or body
+}
+
+// DecoderFunc decodes one envelope for a gateway model.
+type DecoderFunc func(env Envelope) Decoded
+
+var decoders = map[string]DecoderFunc{}
+
+// RegisterDecoder routes envelopes with this gw_hw to fn. Unregistered models
+// fall back to decodeJSONPassthrough.
+func RegisterDecoder(gwHW string, fn DecoderFunc) {
+	decoders[strings.ToUpper(strings.TrimSpace(gwHW))] = fn
+}
+
+func init() {
+	RegisterDecoder("MKGW4", decodeMKGW4Envelope)
+	RegisterDecoder("MKGWMINI01", decodeMini01Envelope)
+}
+
+func decoderFor(gwHW string) DecoderFunc {
+	if fn, ok := decoders[gwHW]; ok {
+		return fn
+	}
+	return decodeJSONPassthrough
+}
+
+// decodeMKGW4Envelope decodes the TLV body (no EF30 header in our pipeline).
+func decodeMKGW4Envelope(env Envelope) Decoded {
+	bodyHex := strings.ToUpper(strings.NewReplacer(" ", "", ":", "", "-", "", ".", "").Replace(env.PayloadHex))
+
+	// Extract "3089" from "self/3089" (or "3004", "30B1", etc.)
+	flagUp := strings.ToUpper(strings.TrimSpace(env.Flag)) // "SELF/3004"
+	flagHex := strings.TrimPrefix(flagUp, "SELF/")
+	if flagHex == "" {
+		// A framed uplink (EF3004...) names its own flag in the header.
+		if f := deriveHeaderFlag(bodyHex); flagParsers[f] != nil {
+			flagHex = f
+		}
+	}
+
+	auto, ok, err := decodeMKGW4Cached(flagHex, bodyHex)
+	assumed := false
+	if def := defaultFlags[env.GWHW]; flagHex == "" && !ok && def != "" {
+		// Neither the envelope nor the body names the flag: try the model's.
+		flagHex, assumed = def, true
+		auto, ok, err = decodeMKGW4Cached(flagHex, bodyHex)
+	}
+	if !ok || auto == nil {
+		flag := emptyFlag()
+		if flagHex != "" {
+			flag = "self/" + flagHex
+		}
+		return Decoded{Payload: bodyHex, Flag: flag, Err: err, FlagAssumed: assumed}
+	}
+	return Decoded{
+		Auto:        auto,
+		Payload:     strings.ToUpper(auto.Hex),
+		Flag:        "self/" + strings.ToUpper(auto.Flag),
+		Err:         err,
+		FlagAssumed: assumed,
+	}
+}
+
+// decodeJSONPassthrough stores JSON gateway bodies (MKGW3/MKGW1BWPRO/...) as-is.
+func decodeJSONPassthrough(env Envelope) Decoded {
+	flag := emptyFlag()
+	if t := jsonFrameType(env.PayloadHex); t != "" {
+		flag = "json/" + t
+	}
+	return Decoded{Payload: env.PayloadHex, Flag: flag, Invalid: jsonPayloadProblem(env.PayloadHex)}
+}
+
+// jsonPayloadProblem reports why a JSON gateway body would break jsonb
+// consumers downstream, or "" when it is fine.
+func jsonPayloadProblem(s string) string {
+	switch {
+	case !utf8.ValidString(s):
+		return "invalid utf-8"
+	case !json.Valid([]byte(s)):
+		return "invalid json"
+	}
+	return ""
+}
+
+// emptyFlag is the flag stored when neither the envelope nor the body gives
+// one: "json", or "" with EMPTY_FLAG=keep so queries can tell unknown apart.
+func emptyFlag() string {
+	if keepEmptyFlag {
+		return ""
+	}
+	return "json"
+}
+
+func decodeMini01Envelope(env Envelope) Decoded {
+	d := decodeJSONPassthrough(env)
+	auto, ok, err := DecodeMKGWMini01([]byte(env.PayloadHex))
+	if ok {
+		d.Auto = auto
+	}
+	d.Err = err
+	return d
+}
@@ -3,11 +3,14 @@
 Deploy a prebuilt ble-gw-auto-parser image to Cloud Run, wiring:
 - Secrets via Secret Manager (--set-secrets)
 - Plain env vars from .env (--set-env-vars)
+Schema: migrations/*.sql are applied in order with psql against MIGRATE_DSN
+(from .env) before the deploy; each is idempotent, so re-runs are safe.
 #>
 
 [CmdletBinding()]
 param(
-  [switch]$SkipSecrets
+  [switch]$SkipSecrets,
+  [switch]$SkipMigrations
 )
 
 Set-StrictMode -Version Latest
@@ -68,6 +71,7 @@ Write-Host "ServiceAcct:   $saEmail"
 Write-Host "Env PROJECT_ID:        $PROJECT_ID"
 Write-Host "Env PUBSUB_TOPIC_GW_SELF: $PUBSUB_TOPIC_GW_SELF"
 Write-Host "SkipSecrets:   $SkipSecrets"
+Write-Host "SkipMigrations: $SkipMigrations"
 Write-Host ""
 
 # 3) Ensure project set
@@ -84,11 +88,23 @@ if (-not $saExists) {
 # 5) Grant IAM: Cloud SQL Client
 Invoke-Cmd $Gcloud @("projects","add-iam-policy-binding",$PROJECT_ID,"--member","serviceAccount:$saEmail","--role","roles/cloudsql.client")
 
+# 5b) Apply schema migrations (the new image writes their columns)
+if (-not $SkipMigrations) {
+  $MIGRATE_DSN = Get-Variable -Name MIGRATE_DSN -ValueOnly -ErrorAction SilentlyContinue
+  if (-not $MIGRATE_DSN) { throw "MIGRATE_DSN missing in .env (or pass -SkipMigrations)" }
+  $Psql = Test-ToolInstalled -Name "psql"
+  Get-ChildItem -LiteralPath (Join-Path $PSScriptRoot "migrations") -Filter "*.sql" | Sort-Object Name | ForEach-Object {
+    Write-Host "→ migration $($_.Name)" -ForegroundColor Cyan
+    & $Psql $MIGRATE_DSN -v ON_ERROR_STOP=1 --single-transaction -q -f $_.FullName
+    if ($LASTEXITCODE -ne 0) { throw "Migration failed ($LASTEXITCODE): $($_.Name)" }
+  }
+}
+
 # 6) Build secrets list dynamically (include only those that exist)
 $requiredSecrets = @("DB_USER","DB_PASSWORD","DB_NAME","INSTANCE_CONNECTION_NAME")
 
 # Optional envs that might be provided via Secret Manager; if present, prefer secret over literal
-$optionalSecretEnvVars = @("PROJECT_ID","PUBSUB_TOPIC_GW_SELF")
+$optionalSecretEnvVars = @("PROJECT_ID","PUBSUB_TOPIC_GW_SELF","GWAUTO_ADMIN_TOKEN")
 
 $pairs = @()      # for --set-secrets
 foreach ($name in $requiredSecrets + $optionalSecretEnvVars) {
@@ -156,3 +172,4 @@ Write-Host "  GWAUTO_ENDPOINT=$URL/auto"
 Write-Host "  GWAUTO_QUEUE_SIZE=100"
 Write-Host "  GWAUTO_TIMEOUT_MS=1500"
 Write-Host "  # Authorization is enforced by GWAUTO_AUTH_TOKEN secret (if set)."
+Write-Host "  # /admin/* needs the GWAUTO_ADMIN_TOKEN secret; without it they return 403."
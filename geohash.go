@@ -0,0 +1,49 @@
+package main
+
+// geohashBase32 is the geohash alphabet (no a, i, l, o).
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash encodes lat/lon to precision characters (standard interleaved
+// bisection, longitude bit first).
+func geohash(lat, lon float64, precision int) string {
+	latLo, latHi, lonLo, lonHi := -90.0, 90.0, -180.0, 180.0
+	out := make([]byte, 0, precision)
+	even, bit, ch := true, 0, 0
+	for len(out) < precision {
+		if even {
+			mid := (lonLo + lonHi) / 2
+			if lon >= mid {
+				ch = ch<<1 | 1
+				lonLo = mid
+			} else {
+				ch <<= 1
+				lonHi = mid
+			}
+		} else {
+			mid := (latLo + latHi) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				latLo = mid
+			} else {
+				ch <<= 1
+				latHi = mid
+			}
+		}
+		even = !even
+		if bit++; bit == 5 {
+			out = append(out, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(out)
+}
+
+// fixGeohash is the fix's geohash at GEOHASH_PRECISION, nil when the fix has
+// no (accepted) position.
+func fixGeohash(f *AutoFix) *string {
+	if f == nil || !f.Has("lat") {
+		return nil
+	}
+	gh := geohash(f.Latitude, f.Longitude, geohashPrecision)
+	return &gh
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +24,7 @@ import (
 	"ble-gw-auto-parser/storage"
 
 	pubsub "cloud.google.com/go/pubsub"
+	"github.com/jackc/pgx/v5"
 )
 
 type Envelope struct {
@@ -24,43 +35,68 @@ type Envelope struct {
 	Flag       string `json:"flag"`         // e.g. "self/30A0", "scan_incomplete/30A0", "msg/3004"
 	DeviceTsMs int64  `json:"device_ts_ms"` // may be 0
 	PayloadHex string `json:"payload_hex"`  // MKGW4: EF30.. hex; JSON gateways: minified JSON string
+
+	// PayloadJSON lets JSON gateways send the body inline as an object; it is
+	// compacted into PayloadHex when payload_hex is empty.
+	PayloadJSON json.RawMessage `json:"payload_json,omitempty"`
 }
 
 var (
-	authToken string
-	store     *storage.Store
-	psClient  *pubsub.Client
-	psTopic   *pubsub.Topic
+	authToken  string
+	adminToken string          // GWAUTO_ADMIN_TOKEN: required by /admin/*, which are off when unset
+	allowedNet []netip.Prefix  // empty = any source
+	dupStatus  = http.StatusOK // DUP_STATUS: 200 (default) or 409
+	store      *storage.Store
+	psClient   *pubsub.Client
+	psTopic    *pubsub.Topic
+	psAlerts   *pubsub.Topic // PUBSUB_TOPIC_ALERTS (optional): tamper-positive and crash-dump frames
 )
 
 func main() {
+	// DB_OPTIONAL=1: a failed connect degrades to publish-only (decode +
+	// publish, no receipts or row writes) instead of exiting.
 	if err := db.Connect(); err != nil {
-		log.Fatalf("db connect: %v", err)
-	}
-	defer db.Pool.Close()
-
-	projectID := os.Getenv("PROJECT_ID")         // or "GOOGLE_CLOUD_PROJECT"
-	topicID := os.Getenv("PUBSUB_TOPIC_GW_SELF") // e.g. "gateway-self.parsed"
-
-	if projectID == "" || topicID == "" {
-		log.Fatal("missing GCP_PROJECT or PUBSUB_TOPIC_GW_SELF")
+		if !envBool("DB_OPTIONAL", false) {
+			log.Fatalf("db connect: %v", err)
+		}
+		log.Printf("WARNING: db connect failed, running PUBLISH-ONLY (no idempotency, no row writes): %v", err)
+	} else {
+		defer db.Pool.Close()
+		store = storage.New()
 	}
 
-	var err error
-	psClient, err = pubsub.NewClient(context.Background(), projectID)
-	if err != nil {
-		log.Fatalf("pubsub.NewClient: %v", err)
+	// PUBSUB_OPTIONAL=1: a failed Pub/Sub init degrades to store-only (rows
+	// are written, nothing is published) instead of exiting.
+	if err := initPubSub(); err != nil {
+		if !envBool("PUBSUB_OPTIONAL", false) {
+			log.Fatal(err)
+		}
+		if store == nil {
+			log.Fatalf("neither db nor pubsub available: %v", err)
+		}
+		log.Printf("WARNING: pubsub init failed, running STORE-ONLY (no publish): %v", err)
 	}
-	psTopic = psClient.Topic(topicID)
-	// optional: enable ordering if you created the topic with ordering enabled
-	// psTopic.EnableMessageOrdering = true
 
 	authToken = os.Getenv("GWAUTO_AUTH_TOKEN")
-	store = storage.New()
+	adminToken = os.Getenv("GWAUTO_ADMIN_TOKEN")
+	loadConfig()
+	initAudit()
+	if store != nil {
+		startPoolSampler()
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
-	mux.HandleFunc("/auto", handleAuto)
+	registerIngest(mux)
+	mux.HandleFunc("/frames", handleFrames)
+	mux.HandleFunc("/last-fix", handleLastFix)
+	mux.HandleFunc("/fleet", handleFleet)
+	mux.HandleFunc("/stats/daily", handleStatsDaily)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/selftest", handleSelftest)
+	mux.HandleFunc("/admin/clear-parsed", handleClearParsed)
+	mux.HandleFunc("/admin/purge-receipts", handlePurgeReceipts)
+	mux.HandleFunc("/admin/delete-by-mac-prefix", handleDeleteByMACPrefix)
 
 	addr := ":8080"
 	if v := os.Getenv("PORT"); v != "" {
@@ -70,20 +106,23 @@ func main() {
 	log.Fatal(http.ListenAndServe(addr, mux))
 }
 
+// registerIngest mounts the ingest routes; withAudit goes outermost so the
+// timeout 503s are audited too.
+func registerIngest(mux *http.ServeMux) {
+	mux.Handle("/auto", withAudit(withTimeout(handleAuto)))
+	mux.Handle("POST /auto/{gwhw}/{gwmac}", withAudit(withTimeout(handleAutoPath)))
+	mux.Handle("POST /auto/ndjson", withAudit(http.HandlerFunc(handleAutoNDJSON)))
+}
+
 func handleAuto(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// --- Auth (optional) ---
-	if authToken != "" {
-		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if tok == "" || tok != authToken {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
+	// --- Source allowlist + auth (both optional) ---
+	if !checkAccess(w, r) {
+		return
 	}
 
 	// --- Idempotency key required ---
@@ -93,144 +132,390 @@ func handleAuto(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing idempotency", http.StatusBadRequest)
 		return
 	}
-	dup, err := receiptsSeen(r.Context(), idemKey)
-	if err != nil {
-		log.Printf("idempotency check error: %v", err)
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
-	}
-	if dup {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"ok":true,"dup":true}`))
-		return
-	}
 
 	// --- Parse body ---
 	var env Envelope
 	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
 		log.Printf("400 bad json: %v", err)
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) && te.Field == "payload_hex" {
+			http.Error(w, "bad json (payload_hex must be a string; send objects as payload_json)", http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	env.GWHW = strings.ToUpper(strings.TrimSpace(env.GWHW))
-	env.GWMAC = strings.ToUpper(strings.TrimSpace(env.GWMAC))
 
-	if env.GWHW == "" || env.GWMAC == "" || env.PayloadHex == "" {
-		log.Printf("400 missing fields: gw_hw=%q gw_mac=%q payload_hex_len=%d", env.GWHW, env.GWMAC, len(env.PayloadHex))
-		http.Error(w, "missing fields (gw_hw, gw_mac, payload_hex)", http.StatusBadRequest)
+	processEnvelope(r.Context(), idemKey, env, wantsSyncAck(r)).write(w, r.URL.Query().Get("debug") == "1")
+}
+
+// maxPathBody bounds the raw-hex body accepted on the path-based route.
+const maxPathBody = 1 << 20
+
+// handleAutoPath serves POST /auto/{gwhw}/{gwmac} for constrained firmwares
+// that can't build the JSON envelope: the body is the bare payload, the flag
+// comes from the X-Flag header (or ?flag=), device time from ?device_ts_ms=.
+func handleAutoPath(w http.ResponseWriter, r *http.Request) {
+	if !checkAccess(w, r) {
 		return
 	}
-	if len(env.GWMAC) != 12 {
-		http.Error(w, "bad gw_mac (expect 12 hex chars, no separators)", http.StatusBadRequest)
+
+	idemKey := r.Header.Get("X-Idempotency-Key")
+	if strings.TrimSpace(idemKey) == "" {
+		log.Printf("400 missing idempotency; headers=%v", r.Header)
+		http.Error(w, "missing idempotency", http.StatusBadRequest)
 		return
 	}
 
-	// --- Normalize / parse per gateway type ---
-	ts := time.UnixMilli(env.DeviceTsMs) // may be zero -> 1970-01-01
-	flagToStore := strings.TrimSpace(env.Flag)
-	payloadToStore := env.PayloadHex
-
-	var st *storage.AutoStatus
-	var fx *storage.AutoFix
+	mac, err := ParseMAC(r.PathValue("gwmac"))
+	if err != nil {
+		http.Error(w, "bad gw_mac (expect "+macLengthsText()+" hex chars)", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPathBody))
+	if err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
 
-	log.Printf("Entering the switch, flag=%s, env.GWHW=%s", flagToStore, env.GWHW)
-	switch env.GWHW {
-	case "MKGW4":
-		// Normalize TLV body (no EF30 header in our pipeline)
-		bodyHex := strings.ToUpper(strings.NewReplacer(" ", "", ":", "", "-", "", ".", "").Replace(env.PayloadHex))
-		payloadToStore = bodyHex
+	env := Envelope{
+		GWHW:       r.PathValue("gwhw"),
+		GWMAC:      strings.ToUpper(hex.EncodeToString(mac)),
+		Flag:       r.Header.Get("X-Flag"),
+		PayloadHex: strings.TrimSpace(string(body)),
+	}
+	if env.Flag == "" {
+		env.Flag = r.URL.Query().Get("flag")
+	}
+	if v := r.URL.Query().Get("device_ts_ms"); v != "" {
+		if env.DeviceTsMs, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "bad device_ts_ms", http.StatusBadRequest)
+			return
+		}
+	}
 
-		// Extract "3089" from "self/3089" (or "3004", "30B1", etc.)
-		rawFlag := strings.TrimSpace(flagToStore) // e.g. "self/3004"
-		flagUp := strings.ToUpper(rawFlag)        // "SELF/3004"
-		flagHex := strings.TrimPrefix(flagUp, "SELF/")
+	processEnvelope(r.Context(), idemKey, env, wantsSyncAck(r)).write(w, r.URL.Query().Get("debug") == "1")
+}
 
-		log.Printf("flagUp=%q flagHex=%q", flagUp, flagHex)
-		log.Printf("bodyHex=%q", bodyHex)
+// ingestResult is the outcome of one envelope: a status plus either a JSON
+// body or a plain-text error.
+type ingestResult struct {
+	Status int
+	Body   map[string]any
+	Err    string
+	Echo   map[string]any // normalized input on 4xx, returned as "debug" with ?debug=1
+}
 
-		auto, ok, decErr := DecodeMKGW4Auto(flagHex, bodyHex)
-		log.Printf("auto=%v ok=%v decErr=%x", auto, ok, decErr)
+func errResult(status int, msg string) ingestResult {
+	return ingestResult{Status: status, Err: msg}
+}
 
-		if decErr != nil {
-			log.Printf("decode warn (MKGW4): %v", decErr)
+// write sends res; with debug a 4xx becomes JSON carrying the echo of what
+// we extracted from the request (off by default: it reflects client input).
+func (res ingestResult) write(w http.ResponseWriter, debug bool) {
+	if debug && res.Echo != nil {
+		body := map[string]any{"ok": false, "error": res.Err}
+		if res.Err == "" {
+			body = maps.Clone(res.Body)
 		}
-		if ok && auto != nil {
-			log.Printf("flagToStore=%s", flagToStore)
-			if flagToStore == "" {
-				flagToStore = "self/" + strings.ToUpper(auto.Flag)
-			}
-			payloadToStore = strings.ToUpper(auto.Hex)
-			log.Printf("payloadToStore=%s", payloadToStore)
-			log.Printf("auto.Timestamp=%d", auto.Timestamp)
-			if auto.Timestamp != 0 {
-				ts = time.Unix(auto.Timestamp, 0).UTC()
-			}
-			log.Printf("auto.Status=%x", auto.Status)
-			if auto.Status != nil {
-				st = &storage.AutoStatus{
-					NetworkType: auto.Status.NetworkType,
-					CSQ:         auto.Status.CSQ,
-					BattmV:      auto.Status.BattmV,
-					AxisXmg:     auto.Status.AxisXmg,
-					AxisYmg:     auto.Status.AxisYmg,
-					AxisZmg:     auto.Status.AxisZmg,
-					AccStatus:   auto.Status.AccStatus,
-					IMEI:        auto.Status.IMEI,
-					ICCID:       auto.Status.ICCID,
-				}
-			}
-			log.Printf("auto.Fix=%x", auto.Fix)
-			if auto.Fix != nil {
-				fx = &storage.AutoFix{
-					FixMode:   auto.Fix.FixMode,
-					FixResult: auto.Fix.FixResult,
-					Longitude: auto.Fix.Longitude,
-					Latitude:  auto.Fix.Latitude,
-					TacLac:    auto.Fix.TacLac,
-					CI:        auto.Fix.CI,
-				}
+		body["debug"] = res.Echo
+		writeJSON(w, res.Status, body)
+		return
+	}
+	if res.Err != "" {
+		http.Error(w, res.Err, res.Status)
+		return
+	}
+	writeJSON(w, res.Status, res.Body)
+}
+
+// processEnvelope is the ingest pipeline shared by every route: validate,
+// idempotency receipt, decode, write back, publish.
+func processEnvelope(ctx context.Context, idemKey string, env Envelope, syncAck bool) (result ingestResult) {
+	start := time.Now()
+	defer func() {
+		if result.Status >= 400 && result.Status < 500 {
+			result.Echo = map[string]any{
+				"gw_hw": env.GWHW, "gw_mac": env.GWMAC, "flag": env.Flag, "payload_len": len(env.PayloadHex),
 			}
+		}
+		noteAudit(ctx, env.GWMAC, result)
+	}()
+
+	mac, res, ok := normalizeEnvelope(&env)
+	if !ok {
+		return res
+	}
+
+	// Receipt + parse write share one transaction, committed only after both.
+	// No store (DB_OPTIONAL publish-only mode) means no tx at all.
+	var tx *storage.Tx
+	if store != nil {
+		var err error
+		if tx, err = store.Begin(ctx); err != nil {
+			log.Printf("begin tx error: %v", err)
+			return errResult(http.StatusInternalServerError, "server error")
+		}
+		defer tx.Rollback(context.Background())
+
+		if res, ok := insertReceipt(ctx, tx, mac, idemKey, env.RowID); !ok {
+			return res
+		}
+	}
+
+	d, res, ok := decodeEnvelope(env, start)
+	if !ok {
+		return res
+	}
+	st, fx, ts, flagToStore, parsed := d.st, d.fx, d.ts, d.flag, d.parsed
+
+	// Write back into SAME gateway_message row (parser + parser_json + denorm columns)
+	if tx != nil && env.RowID != nil && *env.RowID > 0 {
+		var err error
+		if (st == nil && fx == nil) || hasKey(rawOnlyFlags, bareFlag(flagToStore)) {
+			// Nothing to denormalize (JSON gateways), or RAW_ONLY_FLAGS says the
+			// columns aren't worth the write: keep whatever columns the row has.
+			err = tx.UpdateGatewayParsedByID(ctx, *env.RowID, parserNameFor(env.GWHW), parsed)
 		} else {
-			if flagToStore == "" {
-				flagToStore = "self/" + flagHex
+			err = tx.UpdateGatewayParsedAndDenormByID(
+				ctx,
+				*env.RowID,
+				parserNameFor(env.GWHW),
+				parsed,
+				ts,
+				st,
+				fx,
+				d.warnings,
+			)
+		}
+		if err != nil {
+			log.Printf("update parsed err (id=%d): %v", *env.RowID, err)
+			// A missing row won't appear on retry, so keep the receipt; anything
+			// else rolls back so the client's retry is reprocessed.
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return errResult(http.StatusInternalServerError, "server error")
 			}
-			payloadToStore = bodyHex
 		}
-	default:
-		// JSON gateways (MKGW3/MKGW1BWPRO/MINI...). Store JSON body as-is.
-		if flagToStore == "" {
-			flagToStore = "json"
+	}
+	// INSERT_MODE: no row to update, so the decode becomes a new row, and
+	// the receipt points at it.
+	var insertedID *int64
+	if tx != nil && env.RowID == nil && insertMode {
+		id, err := tx.InsertParsed(ctx, insertRowFor(mac, env, d))
+		if err == nil {
+			err = tx.SetReceiptRows(ctx, receiptMACs(mac), []string{idemKey}, []int64{id})
+		}
+		if err != nil {
+			log.Printf("insert parsed err: %v", err)
+			return errResult(http.StatusInternalServerError, "server error")
+		}
+		insertedID = &id
+		env.RowID = insertedID
+	}
+	// Default is fire-and-forget publish. With syncAck we wait for the ack
+	// BEFORE committing, so a failed publish rolls back the receipt and the
+	// client's retry is reprocessed rather than deduped.
+	if !syncAck && tx != nil {
+		if err := tx.Commit(ctx); err != nil {
+			log.Printf("commit error: %v", err)
+			return errResult(http.StatusInternalServerError, "server error")
+		}
+	}
+
+	published, res, ok := publishEnvelope(ctx, env, d, tx, syncAck)
+	if !ok {
+		return res
+	}
+	if syncAck && tx != nil {
+		if err := tx.Commit(ctx); err != nil {
+			log.Printf("commit error: %v", err)
+			return errResult(http.StatusInternalServerError, "server error")
 		}
-		payloadToStore = env.PayloadHex
+	}
+
+	log.Printf(`{"event":"stored+published","gw_hw":"%s","flag":"%s","len":%d,"row_id":%v,"took_ms":%d}`,
+		env.GWHW, flagToStore, len(d.payload), env.RowID != nil, time.Since(start).Milliseconds())
+
+	return storedResult(d, syncAck, published, insertedID)
+}
+
+// normalizeEnvelope validates env and canonicalizes it in place, returning
+// the gateway MAC bytes; !ok means res is the rejection to send.
+func normalizeEnvelope(env *Envelope) (mac []byte, res ingestResult, ok bool) {
+	env.GWHW = strings.ToUpper(strings.TrimSpace(env.GWHW))
+	env.GWMAC = strings.ToUpper(strings.TrimSpace(env.GWMAC))
+	if env.PayloadHex == "" && len(env.PayloadJSON) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, env.PayloadJSON); err != nil {
+			return nil, errResult(http.StatusBadRequest, "bad payload_json"), false
+		}
+		env.PayloadHex = buf.String()
+	}
+
+	if env.GWHW == "" || env.GWMAC == "" || env.PayloadHex == "" {
+		log.Printf("400 missing fields: gw_hw=%q gw_mac=%q payload_hex_len=%d", env.GWHW, env.GWMAC, len(env.PayloadHex))
+		return nil, errResult(http.StatusBadRequest, "missing fields (gw_hw, gw_mac, payload_hex)"), false
+	}
+	// Accept "CC:E0:1B:A2:06:24" / "cc-e0-..." but store the canonical form.
+	mac, err := ParseMAC(env.GWMAC)
+	if err != nil {
+		return nil, errResult(http.StatusBadRequest, "bad gw_mac (expect "+macLengthsText()+" hex chars)"), false
+	}
+	env.GWMAC = strings.ToUpper(hex.EncodeToString(mac))
+	if len(env.PayloadHex) > maxPayloadHex {
+		log.Printf("413 payload too large: gw_hw=%q gw_mac=%q payload_hex_len=%d", env.GWHW, env.GWMAC, len(env.PayloadHex))
+		return nil, ingestResult{Status: http.StatusRequestEntityTooLarge, Body: map[string]any{
+			"ok": false, "error": "payload_too_large", "len": len(env.PayloadHex), "max": maxPayloadHex,
+		}}, false
+	}
+	return mac, ingestResult{}, true
+}
+
+// insertReceipt records the idempotency receipt in tx; !ok means res is
+// the dup or error response to send.
+func insertReceipt(ctx context.Context, tx *storage.Tx, mac []byte, idemKey string, rowID *int64) (res ingestResult, ok bool) {
+	var dup bool
+	var err error
+	if idemPerMAC {
+		dup, err = tx.InsertReceiptForMAC(ctx, mac, idemKey, rowID)
+	} else {
+		dup, err = tx.InsertReceipt(ctx, idemKey, rowID)
+	}
+	if err != nil {
+		log.Printf("idempotency check error: %v", err)
+		return errResult(http.StatusInternalServerError, "server error"), false
+	}
+	if dup {
+		return ingestResult{Status: dupStatus, Body: map[string]any{"ok": true, "dup": true}}, false
+	}
+	return ingestResult{}, true
+}
+
+// receiptMACs is the gwMACs argument of Tx.SetReceiptRows for one receipt.
+func receiptMACs(mac []byte) [][]byte {
+	if !idemPerMAC {
+		return nil
+	}
+	return [][]byte{mac}
+}
+
+// decodedEnvelope is what decodeEnvelope derives from an envelope: the
+// parsed view plus the columns and publish fields built from it.
+type decodedEnvelope struct {
+	ts       time.Time
+	flag     string // flag stored and published
+	payload  string // normalized payload to publish
+	parsed   map[string]any
+	auto     *Auto // nil for JSON gateways
+	st       *storage.AutoStatus
+	fx       *storage.AutoFix
+	warnings []string
+}
+
+// insertRowFor is the INSERT_MODE row for a decoded envelope.
+func insertRowFor(mac []byte, env Envelope, d decodedEnvelope) storage.InsertRow {
+	row := storage.InsertRow{
+		GWMAC:      mac,
+		TsDevice:   d.ts,
+		PayloadHex: env.PayloadHex,
+		Parser:     parserNameFor(env.GWHW),
+		Parsed:     d.parsed,
+	}
+	if !hasKey(rawOnlyFlags, bareFlag(d.flag)) {
+		row.Status, row.Fix, row.Warnings = d.st, d.fx, d.warnings
+	}
+	return row
+}
+
+// storedResult is the 200 for a stored envelope; insertedID is the new row
+// in INSERT_MODE.
+func storedResult(d decodedEnvelope, syncAck, published bool, insertedID *int64) ingestResult {
+	body := map[string]any{"ok": true}
+	if syncAck {
+		body["published"] = published
+	}
+	if insertedID != nil {
+		body["row_id"] = *insertedID
+	}
+	if len(d.warnings) > 0 {
+		body["warnings"] = d.warnings // stored fine, but the firmware sent something odd
+	}
+	return ingestResult{Status: http.StatusOK, Body: body}
+}
+
+// decodeEnvelope runs the gateway's decoder and builds the parsed view;
+// !ok means res is the rejection to send.
+func decodeEnvelope(env Envelope, start time.Time) (d decodedEnvelope, res ingestResult, ok bool) {
+	ts := time.UnixMilli(env.DeviceTsMs) // may be zero -> 1970-01-01
+	flagToStore := strings.TrimSpace(env.Flag)
+
+	var st *storage.AutoStatus
+	var fx *storage.AutoFix
+	var decoded *Auto
+
+	dec := decoderFor(env.GWHW)(env)
+	if dec.Err != nil {
+		log.Printf("decode warn (%s): %v", env.GWHW, dec.Err)
+	}
+	if dec.Invalid != "" && jsonPayloadCheck == "reject" {
+		log.Printf("422 bad json payload: gw_hw=%q gw_mac=%q: %s", env.GWHW, env.GWMAC, dec.Invalid)
+		return decodedEnvelope{}, ingestResult{Status: http.StatusUnprocessableEntity, Body: map[string]any{
+			"ok": false, "error": "bad_payload", "detail": dec.Invalid,
+		}}, false
+	}
+	payloadToStore := dec.Payload
+	if flagToStore == "" {
+		flagToStore = dec.Flag
+	}
+	if dec.Auto != nil {
+		decoded = dec.Auto
+		decoded.applyEpochOffset(epochOffsets[env.GWHW])
+		if decoded.Timestamp != 0 {
+			ts = time.Unix(decoded.Timestamp, 0).UTC()
+		}
+		st = toStorageStatus(decoded.Status)
+		fx = toStorageFix(decoded.Fix)
+	}
+
+	if requireDeviceTs && env.DeviceTsMs == 0 && (decoded == nil || !decoded.HasFrameTs) {
+		log.Printf("400 missing device timestamp: gw_hw=%s gw_mac=%s flag=%s", env.GWHW, env.GWMAC, flagToStore)
+		return decodedEnvelope{}, errResult(http.StatusBadRequest, "missing device timestamp (device_ts_ms or frame ts)"), false
 	}
 
 	// Build parsed view for gateway_parser_json
 	parsed := map[string]any{
 		"kind":         "gateway_self",
 		"version":      1,
-		"source":       "ble-gw-auto-parser",
+		"source":       sourceName,
 		"flag":         flagToStore,
 		"gw_hw":        env.GWHW,
 		"gw_mac":       env.GWMAC,
 		"topic":        env.Topic,
 		"device_ts":    ts.UTC().Format(time.RFC3339Nano),
 		"device_ts_ms": ts.UnixMilli(),
+		"ingest_ts":    start.UTC().Format(time.RFC3339Nano), // server receive time, for latency
+	}
+	if dec.FlagAssumed {
+		parsed["flag_assumed"] = true
+	}
+	if dec.Invalid != "" && jsonPayloadCheck == "flag" {
+		parsed["payload_invalid"] = dec.Invalid
+		parsed["payload_raw_hex"] = strings.ToUpper(hex.EncodeToString([]byte(env.PayloadHex)))
 	}
 	if st != nil {
-		parsed["status"] = map[string]any{
-			"network_type": st.NetworkType,
-			"csq":          st.CSQ,
-			"batt_mv":      st.BattmV,
-			"axis_x_mg":    st.AxisXmg,
-			"axis_y_mg":    st.AxisYmg,
-			"axis_z_mg":    st.AxisZmg,
-			"acc_status":   st.AccStatus,
-			"imei":         st.IMEI,
-			"iccid":        st.ICCID,
+		parsed["status"] = statusJSON(decoded.Status) // decoded is non-nil whenever st is
+	}
+	if decoded != nil && len(decoded.StatusHistory) > 1 {
+		hist := make([]map[string]any, 0, len(decoded.StatusHistory))
+		for _, snap := range decoded.StatusHistory {
+			h := statusJSON(snap.Status)
+			h["ts"] = snap.Ts
+			hist = append(hist, h)
 		}
+		parsed["status_history"] = hist
 	}
 	if fx != nil {
-		parsed["fix"] = map[string]any{
+		fixOut := map[string]any{
 			"mode":    fx.FixMode,
 			"result":  fx.FixResult,
 			"lon":     fx.Longitude,
@@ -238,28 +523,98 @@ func handleAuto(w http.ResponseWriter, r *http.Request) {
 			"tac_lac": fx.TacLac,
 			"ci":      fx.CI,
 		}
+		if !fx.HasPosition {
+			fixOut["lon"], fixOut["lat"] = nil, nil
+		}
+		if !fx.HasCell {
+			fixOut["tac_lac"], fixOut["ci"] = nil, nil
+		}
+		if !fx.HasTacLac {
+			fixOut["tac_lac"] = nil
+		}
+		df := decoded.Fix // non-nil whenever fx is
+		if len(df.NeighborCells) > 0 {
+			fixOut["neighbors"] = neighborsJSON(df.NeighborCells)
+		}
+		if df.Has("downlink_id") {
+			fixOut["downlink_id"] = df.DownlinkID
+		}
+		if df.Has("constellations") {
+			fixOut["constellations"] = df.Constellations
+		}
+		if df.Has("accuracy_m") {
+			fixOut["accuracy_m"] = df.AccuracyM
+		}
+		if df.Has("lac") {
+			fixOut["lac"] = df.LAC
+		}
+		if df.Has("tac") {
+			fixOut["tac"] = df.TAC
+		}
+		if gh := fixGeohash(df); gh != nil {
+			fixOut["geohash"] = *gh
+		}
+		if df.Has("antenna_status") {
+			fixOut["antenna_status"] = df.AntennaStatus
+		}
+		parsed["fix"] = fixOut
 	}
 
-	// Write back into SAME gateway_message row (parser + parser_json + denorm columns)
-	if env.RowID != nil && *env.RowID > 0 {
-		parserName := "gw_json:auto"
-		if env.GWHW == "MKGW4" {
-			parserName = "mkgw4:auto"
+	if decoded != nil {
+		parsed["present_fields"] = decoded.PresentFields()
+		if decoded.HasSeq {
+			parsed["seq_no"] = decoded.SeqNo
+			switch gap, reset := seqs.observe(env.GWMAC, decoded.SeqNo); {
+			case reset:
+				parsed["seq_reset"] = true
+			case gap > 0:
+				parsed["seq_gap"] = gap
+			}
+		}
+		if decoded.Scan != nil {
+			parsed["scan"] = scanJSON(decoded.Scan)
+		}
+		if decoded.Sensors != nil {
+			parsed["sensors"] = sensorsJSON(decoded.Sensors)
 		}
-		if err := store.UpdateGatewayParsedAndDenormByID(
-			r.Context(),
-			*env.RowID,
-			parserName,
-			parsed,
-			ts,
-			st,
-			fx,
-		); err != nil {
-			log.Printf("UpdateGatewayParsedAndDenormID err (id=%d): %v", *env.RowID, err)
+		if decoded.CrashDump != "" {
+			parsed["crash_dump"] = decoded.CrashDump
+		}
+		if p := decoded.OTAProgress; p != nil {
+			parsed["ota"] = map[string]any{"percent": p.Percent, "error": p.Error}
+		}
+		if decoded.Profile != "" {
+			parsed["profile"] = decoded.Profile
+		}
+		if decoded.RawTLVs != nil {
+			parsed["raw_tlvs"] = decoded.RawTLVs
+		}
+		if len(decoded.Warnings) > 0 {
+			parsed["decode_warnings"] = decoded.Warnings
 		}
 	}
 
-	// Publish
+	var warnings []string
+	if decoded != nil && len(decoded.Warnings) > 0 {
+		warnings = decoded.Warnings
+	}
+
+	if contentHashEnabled {
+		parsed["content_hash"] = contentHash(parsed)
+	}
+
+	return decodedEnvelope{
+		ts: ts, flag: flagToStore, payload: payloadToStore, parsed: parsed,
+		auto: decoded, st: st, fx: fx, warnings: warnings,
+	}, ingestResult{}, true
+}
+
+// publishEnvelope publishes the decoded envelope (no-op without a topic).
+// With syncAck it waits for the ack and marks the row in tx; !ok means res
+// is the failure to send.
+func publishEnvelope(ctx context.Context, env Envelope, d decodedEnvelope, tx *storage.Tx, syncAck bool) (bool, ingestResult, bool) {
+	st, fx, ts, flagToStore, payloadToStore, parsed, decoded := d.st, d.fx, d.ts, d.flag, d.payload, d.parsed, d.auto
+	published := false
 	if psTopic != nil {
 		out := map[string]any{
 			"type":          "gateway_self",
@@ -273,78 +628,368 @@ func handleAuto(w http.ResponseWriter, r *http.Request) {
 			"parsed_status": st,
 			"parsed_fix":    fx,
 		}
+		if decoded != nil && decoded.OTAProgress != nil {
+			out["ota"] = parsed["ota"] // OTA dashboard
+		}
+		if decoded != nil && decoded.Sensors != nil {
+			out["sensors"] = parsed["sensors"]
+		}
+		if decoded != nil && decoded.CrashDump != "" {
+			out["crash_dump"] = decoded.CrashDump // firmware team alerting
+		}
 		b, _ := json.Marshal(out)
 
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
-
+		// Routing attributes, so subscription filters don't need the body.
+		attrs := map[string]string{
+			"source":     sourceName,
+			"gw_hw":      env.GWHW,
+			"gw_mac":     env.GWMAC,
+			"flag":       flagToStore,
+			"has_status": strconv.FormatBool(st != nil),
+			"has_fix":    strconv.FormatBool(fx != nil),
+		}
+		if h, ok := parsed["content_hash"].(string); ok {
+			attrs["content_hash"] = h
+		}
+		if pubsubCompress {
+			gz, err := gzipBytes(b)
+			if err != nil {
+				log.Printf("gzip error: %v", err)
+				return false, errResult(http.StatusInternalServerError, "server error"), false
+			}
+			b = gz
+			attrs["content-encoding"] = "gzip"
+		}
+		orderingKey := ""
+		if psTopic.EnableMessageOrdering {
+			orderingKey = env.GWMAC
+		}
 		res := psTopic.Publish(ctx, &pubsub.Message{
-			Data: b,
-			Attributes: map[string]string{
-				"source": "ble-gw-auto-parser",
-			},
+			Data:        b,
+			Attributes:  attrs,
+			OrderingKey: orderingKey,
 		})
-		if _, err := res.Get(ctx); err != nil {
-			log.Printf("pubsub publish error: %v", err)
+		if psAlerts != nil && decoded != nil && (decoded.Status.Tampered() || decoded.CrashDump != "") {
+			publishAlert(b, attrs)
+		}
+		if syncAck {
+			pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			if _, err := res.Get(pubCtx); err != nil {
+				log.Printf("pubsub publish error (sync): %v", err)
+				resumeOrdering(orderingKey)
+				return false, ingestResult{Status: http.StatusBadGateway, Body: map[string]any{"ok": false, "published": false}}, false
+			}
+			published = true
+			if tx != nil && env.RowID != nil {
+				if err := tx.MarkPublished(ctx, *env.RowID); err != nil {
+					log.Printf("MarkPublished err (id=%d): %v", *env.RowID, err)
+				}
+			}
+		} else {
+			go func() {
+				pubCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if _, err := res.Get(pubCtx); err != nil {
+					log.Printf("pubsub publish error: %v", err)
+					resumeOrdering(orderingKey)
+					return
+				}
+				if store != nil && env.RowID != nil {
+					if err := store.MarkPublished(pubCtx, *env.RowID); err != nil {
+						log.Printf("MarkPublished err (id=%d): %v", *env.RowID, err)
+					}
+				}
+			}()
 		}
 	}
+	return published, ingestResult{}, true
+}
 
-	// Done
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"ok":true}`))
+// ---------- helpers ----------
 
-	log.Printf(`{"event":"stored+published","gw_hw":"%s","flag":"%s","len":%d,"row_id":%v,"took_ms":%d}`,
-		env.GWHW, flagToStore, len(payloadToStore), env.RowID != nil, time.Since(start).Milliseconds())
+// initPubSub sets up psClient/psTopic (and psAlerts); the globals stay nil
+// on error.
+func initPubSub() error {
+	projectID := resolveProjectID()              // PROJECT_ID, GOOGLE_CLOUD_PROJECT, or metadata server
+	topicID := os.Getenv("PUBSUB_TOPIC_GW_SELF") // e.g. "gateway-self.parsed"
+
+	if projectID == "" || topicID == "" {
+		return errors.New("missing PROJECT_ID (or GOOGLE_CLOUD_PROJECT / metadata server) or PUBSUB_TOPIC_GW_SELF")
+	}
+
+	client, err := pubsub.NewClient(context.Background(), projectID)
+	if err != nil {
+		return fmt.Errorf("pubsub.NewClient: %w", err)
+	}
+	psClient = client
+	psTopic = psClient.Topic(topicID)
+	psTopic.PublishSettings = publishSettings()
+	if id := os.Getenv("PUBSUB_TOPIC_ALERTS"); id != "" {
+		psAlerts = psClient.Topic(id)
+	}
+	// PUBSUB_ORDERING=1: per-gateway ordering keyed by gw_mac; the topic must
+	// have been created with ordering enabled.
+	psTopic.EnableMessageOrdering = envBool("PUBSUB_ORDERING", false)
+	return nil
 }
 
-// ---------- helpers ----------
+// withTimeout bounds a whole request by REQUEST_TIMEOUT: every stage runs
+// on a context with that deadline and the client gets a 503 once it passes.
+// Not for streaming routes (the timeout writer can't flush).
+func withTimeout(h http.HandlerFunc) http.Handler {
+	return http.TimeoutHandler(h, requestTimeout, "request timeout")
+}
 
-func buildParsedJSON(env Envelope, decoded *Auto, jsonBody any) map[string]any {
-	out := map[string]any{
-		"gw_hw":        env.GWHW,
-		"gw_mac":       env.GWMAC,
-		"flag":         env.Flag,
-		"topic":        env.Topic,
-		"device_ts_ms": env.DeviceTsMs,
-		"kind":         "gateway_self",
-		"source":       "ble-gw-auto-parser",
-		"version":      1,
+// storeAvailable writes 503 when running without a DB (DB_OPTIONAL).
+func storeAvailable(w http.ResponseWriter) bool {
+	if store == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return false
 	}
-	switch {
-	case decoded != nil:
-		out["codec"] = "tlv:mkgw4"
-		out["frame_flag"] = decoded.Flag
-		if decoded.Status != nil {
-			out["status"] = map[string]any{
-				"network_type": decoded.Status.NetworkType,
-				"csq":          decoded.Status.CSQ,
-				"batt_mv":      decoded.Status.BattmV,
-				"axis_x_mg":    decoded.Status.AxisXmg,
-				"axis_y_mg":    decoded.Status.AxisYmg,
-				"axis_z_mg":    decoded.Status.AxisZmg,
-				"acc_status":   decoded.Status.AccStatus,
-				"imei":         decoded.Status.IMEI,
-				"iccid":        decoded.Status.ICCID,
-			}
+	return true
+}
+
+// wantsSyncAck reports whether the client asked to wait for the publish ack
+// (`Prefer: ack=sync` or `?ack=1`).
+func wantsSyncAck(r *http.Request) bool {
+	if r.URL.Query().Get("ack") == "1" {
+		return true
+	}
+	for _, p := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(p), "ack=sync") {
+			return true
 		}
-		if decoded.Fix != nil {
-			out["fix"] = map[string]any{
-				"mode":      decoded.Fix.FixMode,
-				"result":    decoded.Fix.FixResult,
-				"longitude": decoded.Fix.Longitude,
-				"latitude":  decoded.Fix.Latitude,
-				"tac_lac":   decoded.Fix.TacLac,
-				"ci":        decoded.Fix.CI,
-			}
+	}
+	return false
+}
+
+// checkAccess enforces the source allowlist (before auth) and the bearer
+// token, writing 403/401 itself. Returns false when the request must stop.
+func checkAccess(w http.ResponseWriter, r *http.Request) bool {
+	if len(allowedNet) > 0 && !sourceAllowed(r) {
+		log.Printf("403 source not allowed; remote=%s xff=%q", r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if authToken != "" {
+		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tok == "" || tok != authToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
 		}
-		if decoded.Timestamp != 0 {
-			out["frame_ts_s"] = decoded.Timestamp
+	}
+	return true
+}
+
+// checkAdmin is checkAccess for the destructive /admin routes: they need
+// their own bearer token, and are refused outright when none is configured.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if len(allowedNet) > 0 && !sourceAllowed(r) {
+		log.Printf("403 source not allowed; remote=%s xff=%q", r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if adminToken == "" {
+		http.Error(w, "admin disabled", http.StatusForbidden)
+		return false
+	}
+	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tok == "" || tok != adminToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// bareFlag strips the "self/" prefix and upper-cases: "self/30a0" -> "30A0".
+func bareFlag(f string) string {
+	f = strings.TrimSpace(f)
+	if len(f) > 5 && strings.EqualFold(f[:5], "self/") {
+		f = f[5:]
+	}
+	return strings.ToUpper(f)
+}
+
+// ParseMAC normalizes a gateway identifier ("CC:E0:1B:A2:06:24",
+// "cce01ba20624", ...) into its byte form: 6 bytes for a MAC, 8 for the
+// 16-char extended id newer gateways send, when MAC_LENGTHS allows it.
+func ParseMAC(s string) ([]byte, error) {
+	clean := strings.ToUpper(strings.NewReplacer(" ", "", ":", "", "-", "", ".", "").Replace(strings.TrimSpace(s)))
+	if !slices.Contains(macLengths, len(clean)) || !onlyHex(clean) {
+		return nil, fmt.Errorf("bad mac %q (expect %s hex chars)", s, macLengthsText())
+	}
+	return hex.DecodeString(clean)
+}
+
+// macLengthsText is macLengths for error messages: "12" or "12 or 16".
+func macLengthsText() string {
+	parts := make([]string, len(macLengths))
+	for i, n := range macLengths {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, " or ")
+}
+
+// statusJSON is the parsed["status"] view of a decoded status: denorm
+// columns (nil when absent) plus the JSON-only fields that were decoded.
+func statusJSON(ds *AutoStatus) map[string]any {
+	st := toStorageStatus(ds)
+	status := map[string]any{
+		"network_type":        st.NetworkType,
+		"csq":                 st.CSQ,
+		"batt_mv":             st.BattmV,
+		"axis_x_mg":           st.AxisXmg,
+		"axis_y_mg":           st.AxisYmg,
+		"axis_z_mg":           st.AxisZmg,
+		"acc_status":          st.AccStatus,
+		"imei":                st.IMEI,
+		"iccid":               st.ICCID,
+		"temp_c":              st.TempC,
+		"humidity":            st.Humidity,
+		"boot_count":          st.BootCount,
+		"uptime_sec":          st.UptimeSec,
+		"batt_temp_c":         st.BattTempC,
+		"rsrp":                st.RSRP,
+		"rsrq":                st.RSRQ,
+		"plmn":                st.PLMN,
+		"config_version":      st.ConfigVersion,
+		"board_temp_c":        st.BoardTempC,
+		"link_quality":        st.LinkQuality,
+		"report_interval_sec": st.ReportIntervalSec,
+		"pending_downlinks":   st.PendingDownlinks,
+		"motion_events":       st.MotionEvents,
+		"moving_sec":          st.MovingSec,
+		"power_source":        st.PowerSource,
+		"charging_state":      st.ChargingState,
+		"axis_magnitude":      st.AxisMagnitude,
+		"tilt_deg":            st.TiltDegrees,
+	}
+	if ds.Has("wake_reason") {
+		status["wake_reason"] = ds.WakeReason
+	}
+	if ds.Has("tamper_state") {
+		status["tamper_state"], status["tamper_ts"] = ds.TamperState, ds.TamperTs
+	}
+	return status
+}
+
+// toStorageStatus maps a decoded status onto the denorm columns.
+func toStorageStatus(s *AutoStatus) *storage.AutoStatus {
+	if s == nil {
+		return nil
+	}
+	return &storage.AutoStatus{
+		NetworkType:       s.NetworkType,
+		CSQ:               s.CSQ,
+		BattmV:            s.BattmV,
+		AxisXmg:           s.AxisXmg,
+		AxisYmg:           s.AxisYmg,
+		AxisZmg:           s.AxisZmg,
+		AccStatus:         s.AccStatus,
+		IMEI:              s.IMEI,
+		ICCID:             s.ICCID,
+		TempC:             opt(s.Has("temp_c"), s.TempC),
+		Humidity:          opt(s.Has("humidity"), s.Humidity),
+		BootCount:         opt(s.Has("boot_count"), s.BootCount),
+		UptimeSec:         opt(s.Has("uptime_sec"), s.UptimeSec),
+		BattTempC:         opt(s.Has("batt_temp_c"), s.BattTempC),
+		RSRP:              opt(s.Has("rsrp"), s.RSRP),
+		RSRQ:              opt(s.Has("rsrq"), s.RSRQ),
+		PLMN:              opt(s.Has("plmn"), s.PLMN),
+		ConfigVersion:     opt(s.Has("config_version"), s.ConfigVersion),
+		BoardTempC:        opt(s.Has("board_temp_c"), s.BoardTempC),
+		LinkQuality:       opt(s.Has("link_quality"), s.LinkQuality),
+		ReportIntervalSec: opt(s.Has("report_interval_sec"), s.ReportIntervalSec),
+		PendingDownlinks:  opt(s.Has("pending_downlinks"), s.PendingDownlinks),
+		MotionEvents:      opt(s.Has("motion_events"), s.MotionEvents),
+		MovingSec:         opt(s.Has("moving_sec"), s.MovingSec),
+		PowerSource:       opt(s.Has("power_source"), s.PowerSource),
+		ChargingState:     opt(s.Has("charging_state"), s.ChargingState),
+		AxisMagnitude:     opt(s.Has("axis_magnitude"), s.AxisMagnitude),
+		TiltDegrees:       opt(s.Has("tilt_deg"), s.TiltDegrees),
+	}
+}
+
+// toStorageFix maps a decoded fix onto the denorm columns.
+func toStorageFix(f *AutoFix) *storage.AutoFix {
+	if f == nil {
+		return nil
+	}
+	return &storage.AutoFix{
+		FixMode:     f.FixMode,
+		FixResult:   f.FixResult,
+		Longitude:   f.Longitude,
+		Latitude:    f.Latitude,
+		TacLac:      f.TacLac,
+		CI:          f.CI,
+		HasPosition: f.Has("lat"),
+		HasCell:     f.Has("ci"),
+		HasTacLac:   f.Has("tac_lac"),
+		AccuracyM:   opt(f.Has("accuracy_m"), f.AccuracyM),
+		LAC:         opt(f.Has("lac"), f.LAC),
+		Geohash:     fixGeohash(f),
+	}
+}
+
+// jsonFrameTypeKeys are tried in order to find a JSON gateway's frame type.
+var jsonFrameTypeKeys = []string{"type", "msg_type", "msg_id"}
+
+// jsonFrameType extracts the self-declared frame type from a JSON gateway
+// body (e.g. {"type":"self",...} -> "self"); "" when absent or not JSON.
+func jsonFrameType(body string) string {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &top); err != nil {
+		return ""
+	}
+	for _, k := range jsonFrameTypeKeys {
+		raw, ok := top[k]
+		if !ok {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			s = strings.ToLower(strings.TrimSpace(s))
+		} else {
+			s = strings.TrimSpace(string(raw)) // numeric ids, e.g. "msg_id":3004
+		}
+		if s != "" && s != "null" {
+			return s
 		}
+	}
+	return ""
+}
+
+// volatileParsedKeys change between deliveries of the same frame and are
+// left out of the content hash.
+var volatileParsedKeys = []string{"content_hash", "ingest_ts", "seq_gap"}
 
-	case jsonBody != nil:
-		out["codec"] = "json"
-		out["body"] = jsonBody
+// contentHash is a SHA-256 over the parsed view minus volatile keys.
+// encoding/json sorts map keys, so the encoding is canonical.
+func contentHash(parsed map[string]any) string {
+	canon := make(map[string]any, len(parsed))
+	for k, v := range parsed {
+		if !hasKey(volatileParsedKeys, k) {
+			canon[k] = v
+		}
+	}
+	b, _ := json.Marshal(canon)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// opt returns &v when the field was decoded, nil otherwise (-> JSON/SQL null).
+func opt[T any](present bool, v T) *T {
+	if !present {
+		return nil
+	}
+	return &v
+}
+
+func neighborsJSON(cells []NeighborCell) []map[string]any {
+	out := make([]map[string]any, 0, len(cells))
+	for _, c := range cells {
+		out = append(out, map[string]any{"ci": c.CI, "rssi": c.RSSI})
 	}
 	return out
 }
@@ -369,58 +1014,113 @@ func looksLikeHex(s string) bool {
 	return true
 }
 
-func publishParsed(ctx context.Context, parsed map[string]any) {
-	if psTopic == nil {
+// publishAlert copies a frame to the alert topic, fire-and-forget: the main
+// publish remains the source of truth.
+func publishAlert(data []byte, attrs map[string]string) {
+	res := psAlerts.Publish(context.Background(), &pubsub.Message{Data: data, Attributes: attrs})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := res.Get(ctx); err != nil {
+			log.Printf("pubsub alert publish error: %v", err)
+		}
+	}()
+}
+
+// resumeOrdering unblocks an ordering key after a failed publish: until then
+// the client fails every later message for that gateway. The failed Get was
+// already bounded by the publish deadline, and ResumePublish itself doesn't
+// block.
+func resumeOrdering(key string) {
+	if key == "" {
 		return
 	}
-	b, _ := json.Marshal(parsed)
-	res := psTopic.Publish(ctx, &pubsub.Message{
-		Data: b,
-		Attributes: map[string]string{
-			"gw_hw": fmt.Sprint(parsed["gw_hw"]),
-			"flag":  fmt.Sprint(parsed["flag"]),
-		},
-	})
-	if _, err := res.Get(ctx); err != nil {
-		log.Printf("pubsub publish error: %v", err)
+	psTopic.ResumePublish(key)
+	log.Printf("pubsub ordering key %s resumed after publish error", key)
+}
+
+// gzipBytes compresses a published payload (PUBSUB_COMPRESS).
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
 	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-// deriveHeaderFlag returns bytes[1..2] as hex (upper) from an EF30... frame in ASCII hex.
+// headerMagics are the accepted first bytes of a framed uplink (HEADER_MAGIC,
+// default "EF"); some firmware variants use EE or FE.
+var headerMagics = []string{"EF"}
+
+// deriveHeaderFlag returns bytes[1..2] as hex (upper) from an EF30... frame in
+// ASCII hex (any case), for any configured magic byte.
 func deriveHeaderFlag(hexStr string) string {
 	// strip separators
 	clean := strings.NewReplacer(" ", "", ":", "", "-", "", ".", "").Replace(hexStr)
 	clean = strings.ToUpper(clean)
-	if len(clean) < 6 || clean[:2] != "EF" {
+	if len(clean) < 6 || !hasKey(headerMagics, clean[:2]) {
 		return ""
 	}
 	return clean[2:6]
 }
 
-func receiptsSeen(ctx context.Context, key string) (dup bool, err error) {
-	tag, err := db.Pool.Exec(ctx, `
-		INSERT INTO gw_auto_receipts (idempotency_key) VALUES ($1)
-		ON CONFLICT (idempotency_key) DO NOTHING
-	`, key)
-	if err != nil {
-		return false, err
+// parseCIDRList parses a comma-separated list of CIDRs; bare IPs are accepted as single hosts.
+func parseCIDRList(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			a, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("bad ip %q: %w", part, err)
+			}
+			out = append(out, netip.PrefixFrom(a, a.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("bad cidr %q: %w", part, err)
+		}
+		out = append(out, p.Masked())
 	}
-	// If nothing was inserted, it was a duplicate.
-	return tag.RowsAffected() == 0, nil
+	return out, nil
 }
 
-func receiptsInsert(ctx context.Context, key string) error {
-	_, err := db.Pool.Exec(ctx, `
-		INSERT INTO gw_auto_receipts (idempotency_key) VALUES ($1)
-		ON CONFLICT (idempotency_key) DO NOTHING
-	`, key)
-	return err
+// clientIP returns the request source. Behind Cloud Run the proxy appends the
+// real peer to X-Forwarded-For, so the LAST hop is the one we trust; earlier
+// hops are client-supplied. Falls back to RemoteAddr.
+func clientIP(r *http.Request) (netip.Addr, bool) {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		if a, err := netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1])); err == nil {
+			return a.Unmap(), true
+		}
+	}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if a, err := netip.ParseAddr(r.RemoteAddr); err == nil {
+		return a.Unmap(), true
+	}
+	return netip.Addr{}, false
 }
 
-func mustEnv(k string) string {
-	v := os.Getenv(k)
-	if v == "" {
-		panic(fmt.Sprintf("missing env %s", k))
+func sourceAllowed(r *http.Request) bool {
+	ip, ok := clientIP(r)
+	if !ok {
+		return false
+	}
+	for _, p := range allowedNet {
+		if p.Contains(ip) {
+			return true
+		}
 	}
-	return v
+	return false
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name, remote, xff string
+		want              string
+	}{
+		{"remote addr", "10.1.2.3:5555", "", "10.1.2.3"},
+		{"xff single hop", "169.254.1.1:80", "10.1.2.3", "10.1.2.3"},
+		{"xff last hop wins", "169.254.1.1:80", "10.9.9.9, 203.0.113.7", "203.0.113.7"},
+		{"spoofed first hop ignored", "169.254.1.1:80", "10.0.0.1,203.0.113.7", "203.0.113.7"},
+		{"mapped v4", "169.254.1.1:80", "::ffff:10.1.2.3", "10.1.2.3"},
+		{"v6 hop", "169.254.1.1:80", "2001:db8::1", "2001:db8::1"},
+		{"bad xff falls back", "10.1.2.3:5555", "garbage", "10.1.2.3"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/auto", nil)
+			r.RemoteAddr = tc.remote
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			got, ok := clientIP(r)
+			if !ok || got.String() != tc.want {
+				t.Errorf("clientIP = %v, %v; want %s", got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	prev := allowedNet
+	allowedNet = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	t.Cleanup(func() { allowedNet = prev })
+
+	cases := []struct {
+		name, xff string
+		want      bool
+	}{
+		{"allowed v4", "10.20.30.40", true},
+		{"allowed v6", "2001:db8::7", true},
+		{"disallowed", "203.0.113.7", false},
+		{"allowed first hop is client-supplied", "10.0.0.1, 203.0.113.7", false},
+		{"allowed last hop", "203.0.113.7, 10.0.0.1", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/auto", nil) // RemoteAddr 192.0.2.1
+			r.Header.Set("X-Forwarded-For", tc.xff)
+			if got := sourceAllowed(r); got != tc.want {
+				t.Errorf("sourceAllowed = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// withFakePubSub points psTopic at an in-memory Pub/Sub server for the test.
+func withFakePubSub(t *testing.T, ordering bool) *pstest.Server {
+	t.Helper()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "test-project",
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	topic, err := client.CreateTopic(ctx, "gateway-self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic.EnableMessageOrdering = ordering
+	t.Cleanup(topic.Stop)
+
+	prevClient, prevTopic := psClient, psTopic
+	psClient, psTopic = client, topic
+	t.Cleanup(func() { psClient, psTopic = prevClient, prevTopic })
+	return srv
+}
+
+func TestPublishEnvelopeSyncAck(t *testing.T) {
+	env := Envelope{GWHW: "MKGW4", GWMAC: "CCE01BA20624"}
+	d := decodedEnvelope{ts: time.Now(), flag: "self/3004", payload: "00"}
+	cases := []struct {
+		name              string
+		syncAck, fail     bool
+		wantPublished, ok bool
+		wantStatus        int
+	}{
+		{"fire and forget", false, false, false, true, 0},
+		{"sync ack", true, false, true, true, 0},
+		{"sync ack publish fails", true, true, false, false, http.StatusBadGateway},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := withFakePubSub(t, false)
+			if tc.fail {
+				srv.SetAutoPublishResponse(false)
+				srv.AddPublishResponse(nil, status.Error(codes.PermissionDenied, "denied"))
+			}
+			published, res, ok := publishEnvelope(context.Background(), env, d, nil, tc.syncAck)
+			if published != tc.wantPublished || ok != tc.ok || res.Status != tc.wantStatus {
+				t.Fatalf("published %v ok %v res %+v", published, ok, res)
+			}
+			if tc.fail && (res.Body["ok"] != false || res.Body["published"] != false) {
+				t.Errorf("body %v", res.Body)
+			}
+			if tc.syncAck && !tc.fail && len(srv.Messages()) != 1 {
+				t.Errorf("%d messages on the topic", len(srv.Messages()))
+			}
+		})
+	}
+}
+
+func TestHandleAutoPath(t *testing.T) {
+	statusHex := tsTLV + tlv(0x02, "1F")
+	cases := []struct {
+		name, url, xFlag  string
+		wantMAC, wantFlag string
+		wantTs            int64
+	}{
+		{"header flag", "/auto/MKGW4/cce01ba20624?ack=1", "self/3004", "CCE01BA20624", "self/3004", 0x65F0B6C0 * 1000},
+		{"query flag", "/auto/MKGW4/CCE01BA20624?ack=1&flag=3004", "", "CCE01BA20624", "3004", 0x65F0B6C0 * 1000},
+		{"colon mac", "/auto/MKGW4/CC:E0:1B:A2:06:24?ack=1", "self/3004", "CCE01BA20624", "self/3004", 0x65F0B6C0 * 1000},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := withFakePubSub(t, false)
+			mux := http.NewServeMux()
+			registerIngest(mux)
+
+			req := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(statusHex+"\n"))
+			req.Header.Set("X-Idempotency-Key", "k1")
+			if tc.xFlag != "" {
+				req.Header.Set("X-Flag", tc.xFlag)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"published":true`) {
+				t.Fatalf("status %d body %s", rec.Code, rec.Body)
+			}
+
+			msgs := srv.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("%d messages", len(msgs))
+			}
+			var out map[string]any
+			if err := json.Unmarshal(msgs[0].Data, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out["gw_hw"] != "MKGW4" || out["gw_mac"] != tc.wantMAC || out["flag"] != tc.wantFlag || out["payload"] != statusHex {
+				t.Errorf("published %v", out)
+			}
+			if out["device_ts_ms"] != float64(tc.wantTs) || out["parsed_status"] == nil {
+				t.Errorf("ts %v status %v", out["device_ts_ms"], out["parsed_status"])
+			}
+		})
+	}
+}
+
+func TestPublishEnvelopeCompress(t *testing.T) {
+	env := Envelope{GWHW: "MKGW4", GWMAC: "CCE01BA20624"}
+	d := decodedEnvelope{ts: time.UnixMilli(1718000000000), flag: "self/3004", payload: "00000465F0B6C0"}
+	var plain []byte
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			prev := pubsubCompress
+			pubsubCompress = compress
+			t.Cleanup(func() { pubsubCompress = prev })
+			srv := withFakePubSub(t, false)
+
+			if _, res, ok := publishEnvelope(context.Background(), env, d, nil, true); !ok {
+				t.Fatalf("publish: %+v", res)
+			}
+			m := srv.Messages()[0]
+			data := m.Data
+			if enc := m.Attributes["content-encoding"]; compress != (enc == "gzip") {
+				t.Fatalf("content-encoding %q", enc)
+			}
+			if compress {
+				zr, err := gzip.NewReader(bytes.NewReader(m.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, plain) {
+					t.Errorf("gunzipped %s\nwant %s", data, plain)
+				}
+				return
+			}
+			plain = data
+			if !json.Valid(plain) {
+				t.Fatalf("not JSON: %s", plain)
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	prev := requestTimeout
+	requestTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = prev })
+
+	cases := []struct {
+		name       string
+		stage      time.Duration // how long the injected stage takes
+		wantStatus int
+	}{
+		{"fast stage", 0, http.StatusOK},
+		{"slow stage trips the deadline", time.Second, http.StatusServiceUnavailable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var hadDeadline, cancelled bool
+			done := make(chan struct{})
+			h := withTimeout(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				_, hadDeadline = r.Context().Deadline()
+				select {
+				case <-time.After(tc.stage):
+					w.WriteHeader(http.StatusOK)
+				case <-r.Context().Done(): // a DB or publish call would see this
+					cancelled = true
+				}
+			})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auto", nil))
+			<-done // the timed-out handler keeps running until it sees ctx
+			if rec.Code != tc.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if !hadDeadline {
+				t.Error("stage ran without a deadline")
+			}
+			if cancelled != (tc.wantStatus == http.StatusServiceUnavailable) {
+				t.Errorf("stage cancelled = %v", cancelled)
+			}
+		})
+	}
+}
+
+func TestPublishEnvelopeResumesOrdering(t *testing.T) {
+	srv := withFakePubSub(t, true)
+	env := Envelope{GWHW: "MKGW4", GWMAC: "CCE01BA20624"}
+	d := decodedEnvelope{ts: time.Now(), flag: "self/3004", payload: "00"}
+
+	srv.SetAutoPublishResponse(false)
+	srv.AddPublishResponse(nil, status.Error(codes.PermissionDenied, "denied"))
+	if _, res, ok := publishEnvelope(context.Background(), env, d, nil, true); ok || res.Status != http.StatusBadGateway {
+		t.Fatalf("failed publish: ok %v res %+v", ok, res)
+	}
+
+	// Without ResumePublish the client would fail this one too (key paused).
+	srv.SetAutoPublishResponse(true)
+	published, res, ok := publishEnvelope(context.Background(), env, d, nil, true)
+	if !ok || !published {
+		t.Fatalf("publish after resume: published %v res %+v", published, res)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 || msgs[0].OrderingKey != env.GWMAC {
+		t.Errorf("messages %+v", msgs)
+	}
+}
+
+func TestReceiptMACs(t *testing.T) {
+	prev := idemPerMAC
+	t.Cleanup(func() { idemPerMAC = prev })
+	mac := []byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+
+	idemPerMAC = false
+	if got := receiptMACs(mac); got != nil {
+		t.Errorf("global scope: %X, want nil", got)
+	}
+	idemPerMAC = true
+	if got := receiptMACs(mac); len(got) != 1 || !bytes.Equal(got[0], mac) {
+		t.Errorf("gw_mac scope: %X", got)
+	}
+}
+
+func TestDebugEcho(t *testing.T) {
+	mux := http.NewServeMux()
+	registerIngest(mux)
+	body := `{"gw_hw":" mkgw4 ","gw_mac":"cc:e0:1b","flag":"3004","payload_hex":"ABCD"}`
+	send := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set("X-Idempotency-Key", "k1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("/auto?debug=1")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d body %s", rec.Code, rec.Body)
+	}
+	var out struct {
+		OK    bool           `json:"ok"`
+		Error string         `json:"error"`
+		Debug map[string]any `json:"debug"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("debug body %s: %v", rec.Body, err)
+	}
+	want := map[string]any{"gw_hw": "MKGW4", "gw_mac": "CC:E0:1B", "flag": "3004", "payload_len": float64(4)}
+	if out.OK || !strings.HasPrefix(out.Error, "bad gw_mac") || fmt.Sprint(out.Debug) != fmt.Sprint(want) {
+		t.Errorf("debug response %+v, want echo %v", out, want)
+	}
+
+	rec = send("/auto")
+	if rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "debug") || strings.Contains(rec.Body.String(), "MKGW4") {
+		t.Errorf("echo without ?debug=1: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"ble-gw-auto-parser/db"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// metric is one Prometheus-style family rendered in the text exposition
+// format. We only need a handful of gauges/counters, so no client library.
+type metric struct {
+	name, help, kind string
+
+	mu     sync.Mutex
+	series map[string]float64 // rendered label set ("" or `{k="v"}`) -> value
+}
+
+var metricFamilies []*metric
+
+func newMetric(kind, name, help string) *metric {
+	m := &metric{name: name, help: help, kind: kind, series: map[string]float64{}}
+	metricFamilies = append(metricFamilies, m)
+	return m
+}
+
+func newGauge(name, help string) *metric   { return newMetric("gauge", name, help) }
+func newCounter(name, help string) *metric { return newMetric("counter", name, help) }
+
+func (m *metric) set(labels string, v float64) {
+	m.mu.Lock()
+	m.series[labels] = v
+	m.mu.Unlock()
+}
+
+func (m *metric) add(labels string, v float64) {
+	m.mu.Lock()
+	m.series[labels] += v
+	m.mu.Unlock()
+}
+
+// GET /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !checkAccess(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metricFamilies {
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.series))
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", m.name, k, m.series[k])
+		}
+		m.mu.Unlock()
+	}
+}
+
+// ---------- Decode ----------
+
+// tlvTagsSeen counts top-level TLV tags per MKGW4 flag, handled or not, to
+// spot undocumented fields. Only registered flags are counted and a tag is
+// one byte, so the series stay bounded (flags x 256). Decode cache hits are
+// not recounted.
+var tlvTagsSeen = newCounter("gwauto_tlv_tags_total", "Top-level TLV tags decoded, by flag and tag.")
+
+func countTLVTags(flag string, body []byte) {
+	walkTLV(body, func(tag byte, _ []byte) bool {
+		tlvTagsSeen.add(fmt.Sprintf(`{flag="%s",tag="0x%02X"}`, flag, tag), 1)
+		return true
+	})
+}
+
+// ---------- DB pool ----------
+
+var (
+	poolAcquired    = newGauge("gwauto_db_pool_acquired_conns", "Connections currently checked out.")
+	poolIdle        = newGauge("gwauto_db_pool_idle_conns", "Idle connections.")
+	poolTotal       = newGauge("gwauto_db_pool_total_conns", "Total open connections.")
+	poolMax         = newGauge("gwauto_db_pool_max_conns", "Configured MaxConns.")
+	poolAcquires    = newCounter("gwauto_db_pool_acquire_total", "Successful acquires.")
+	poolWaits       = newCounter("gwauto_db_pool_empty_acquire_total", "Acquires that had to wait for a free connection.")
+	poolWaitSeconds = newCounter("gwauto_db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections.")
+)
+
+const poolSampleInterval = 10 * time.Second
+
+// samplePool copies pgxpool.Stat() into the pool metrics, labelled
+// pool="primary" and, when a replica is configured, pool="replica". The pool
+// keeps cumulative counts, so counters are set rather than incremented.
+func samplePool() {
+	if db.Pool == nil {
+		return
+	}
+	samplePoolStat(`{pool="primary"}`, db.Pool.Stat())
+	if db.ReadPool != nil && db.ReadPool != db.Pool {
+		samplePoolStat(`{pool="replica"}`, db.ReadPool.Stat())
+	}
+}
+
+func samplePoolStat(labels string, s *pgxpool.Stat) {
+	poolAcquired.set(labels, float64(s.AcquiredConns()))
+	poolIdle.set(labels, float64(s.IdleConns()))
+	poolTotal.set(labels, float64(s.TotalConns()))
+	poolMax.set(labels, float64(s.MaxConns()))
+	poolAcquires.set(labels, float64(s.AcquireCount()))
+	poolWaits.set(labels, float64(s.EmptyAcquireCount()))
+	poolWaitSeconds.set(labels, s.AcquireDuration().Seconds())
+}
+
+func startPoolSampler() {
+	samplePool()
+	go func() {
+		for range time.Tick(poolSampleInterval) {
+			samplePool()
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ble-gw-auto-parser/db"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestSamplePoolLabelsEachPool(t *testing.T) {
+	newPool := func() *pgxpool.Pool { // lazy: never dials
+		p, err := pgxpool.New(context.Background(), "postgres://user@127.0.0.1:1/db?pool_max_conns=3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(p.Close)
+		return p
+	}
+	prevPool, prevRead := db.Pool, db.ReadPool
+	t.Cleanup(func() { db.Pool, db.ReadPool = prevPool, prevRead })
+
+	for _, tc := range []struct {
+		name    string
+		replica bool
+		want    []string
+	}{
+		{"primary only", false, []string{`{pool="primary"}`}},
+		{"with replica", true, []string{`{pool="primary"}`, `{pool="replica"}`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMax.mu.Lock()
+			poolMax.series = map[string]float64{}
+			poolMax.mu.Unlock()
+			db.Pool = newPool()
+			db.ReadPool = db.Pool
+			if tc.replica {
+				db.ReadPool = newPool()
+			}
+			samplePool()
+
+			poolMax.mu.Lock()
+			defer poolMax.mu.Unlock()
+			if len(poolMax.series) != len(tc.want) {
+				t.Errorf("series %v, want %v", poolMax.series, tc.want)
+			}
+			for _, l := range tc.want {
+				if poolMax.series[l] != 3 {
+					t.Errorf("%s max conns %v, want 3", l, poolMax.series[l])
+				}
+			}
+		})
+	}
+}
+
+func TestTLVTagsCountUnhandled(t *testing.T) {
+	tlvTagsSeen.mu.Lock()
+	tlvTagsSeen.series = map[string]float64{}
+	tlvTagsSeen.mu.Unlock()
+
+	body := tsTLV + tlv(0x02, "1F") + tlv(0x7F, "00")
+	for _, tc := range []struct {
+		flag      string
+		countTags bool
+	}{
+		{"3004", true},
+		{"3004", true},
+		{"3004", false}, // e.g. a decode cache hit
+		{"9999", true},  // unregistered flag: not counted
+	} {
+		if _, _, err := decodeMKGW4Auto(tc.flag, body, tc.countTags); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tlvTagsSeen.mu.Lock()
+	series := maps.Clone(tlvTagsSeen.series)
+	tlvTagsSeen.mu.Unlock()
+	if len(series) != 3 || series[`{flag="3004",tag="0x7F"}`] != 2 {
+		t.Errorf("series %v, want 3 with 0x7F counted twice", series)
+	}
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `gwauto_tlv_tags_total{flag="3004",tag="0x7F"} 2`+"\n") {
+		t.Errorf("metrics output missing the unhandled tag:\n%s", rec.Body)
+	}
+}
@@ -0,0 +1,4 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-871] Temperature (tag 0x10) and humidity (tag 0x11) denorm columns.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS temp_c double precision,
+    ADD COLUMN IF NOT EXISTS humidity int;
@@ -0,0 +1,4 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-880] Boot count (tag 0x13) and uptime (tag 0x14).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS boot_count bigint,
+    ADD COLUMN IF NOT EXISTS uptime_sec bigint;
@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-883] Signed battery temperature.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS batt_temp_c int;
@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-887] Decode warnings; NULL when there were none.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS parser_warnings text[];
@@ -0,0 +1,4 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-893] Serving-cell RSRP/RSRQ.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS rsrp int,
+    ADD COLUMN IF NOT EXISTS rsrq int;
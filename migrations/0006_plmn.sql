@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-899] Serving PLMN (tag 0x19).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS plmn text;
@@ -0,0 +1,6 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-902] Receipt age for POST /admin/purge-receipts. Existing receipts get the
+-- migration time, so they survive one more retention window.
+ALTER TABLE gw_auto_receipts
+    ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS gw_auto_receipts_created_at_idx ON gw_auto_receipts (created_at);
@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-903] Config version (tag 0x1A).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS config_version int;
@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-911] Fix accuracy (tag 0x1B).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS accuracy_m int;
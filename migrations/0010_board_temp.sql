@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-918] Board temperature (tag 0x1D).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS board_temp_c double precision;
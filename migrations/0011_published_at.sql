@@ -0,0 +1,6 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-921] Set once the Pub/Sub publish is acked; the catch-up worker claims rows
+-- where it is NULL.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS published_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS gateway_message_unpublished_idx ON public.gateway_message (id) WHERE published_at IS NULL;
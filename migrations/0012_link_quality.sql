@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-928] Link quality (tag 0x1E).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS link_quality int;
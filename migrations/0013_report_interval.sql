@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-931] Reporting interval (tag 0x1F).
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS report_interval_sec int;
@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-941] Tag 0x04 on GSM/UMTS is a LAC and goes here instead of tac.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS lac int;
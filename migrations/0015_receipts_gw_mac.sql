@@ -0,0 +1,9 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-946] Gateway of the receipt, for IDEMPOTENCY_SCOPE=gw_mac.
+ALTER TABLE gw_auto_receipts
+    ADD COLUMN IF NOT EXISTS gw_mac bytea;
+
+-- ON CONFLICT (gw_mac, idempotency_key) needs this index. Switching
+-- IDEMPOTENCY_SCOPE to gw_mac also means dropping the key-only unique
+-- constraint by hand (it would still dedupe across gateways):
+--   ALTER TABLE gw_auto_receipts DROP CONSTRAINT gw_auto_receipts_idempotency_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS gw_auto_receipts_gw_mac_key_idx ON gw_auto_receipts (gw_mac, idempotency_key);
@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-948] Row a receipt processed, for ParsedByIdempotencyKey and the purge.
+ALTER TABLE gw_auto_receipts
+    ADD COLUMN IF NOT EXISTS row_id bigint;
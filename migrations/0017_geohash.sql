@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-950] fix.geohash at GEOHASH_PRECISION.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS geohash text;
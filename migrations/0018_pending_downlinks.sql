@@ -0,0 +1,3 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-951] Pending downlink count.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS pending_downlinks int;
@@ -0,0 +1,4 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-953] Motion event count and moving seconds.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS motion_events int,
+    ADD COLUMN IF NOT EXISTS moving_sec int;
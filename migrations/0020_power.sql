@@ -0,0 +1,4 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-955] Power source and charging state names.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS power_source text,
+    ADD COLUMN IF NOT EXISTS charging_state text;
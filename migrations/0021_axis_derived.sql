@@ -0,0 +1,4 @@
+-- [emmanuel-e2/ble-gw-auto-parser#synth-962] Axis magnitude (mg) and tilt angle (degrees) derived from the axes.
+ALTER TABLE public.gateway_message
+    ADD COLUMN IF NOT EXISTS axis_magnitude double precision,
+    ADD COLUMN IF NOT EXISTS tilt_deg double precision;
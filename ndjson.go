@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ble-gw-auto-parser/storage"
+)
+
+const (
+	ndjsonMaxLine   = 64 << 10 // bytes per envelope line
+	ndjsonMaxLines  = 1000     // envelopes per request
+	ndjsonCopyBatch = 500      // INSERT_MODE lines per COPY
+)
+
+// ndjsonLine is one NDJSON envelope; idempotency_key is per line, falling
+// back to "<X-Idempotency-Key>#<line>" when only the request header is set.
+type ndjsonLine struct {
+	Envelope
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+// POST /auto/ndjson — newline-delimited envelopes, processed as they arrive.
+// Each line gets one NDJSON result line back; a bad line is reported and
+// the stream continues. Status 200 covers the stream, not every line.
+// With INSERT_MODE (and no sync ack) lines without row_id are batched into
+// one COPY per ndjsonCopyBatch; their results, with the new row_id, come
+// back once their batch is written. The stream is bounded by NDJSON_TIMEOUT.
+func handleAutoNDJSON(w http.ResponseWriter, r *http.Request) {
+	if !checkAccess(w, r) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ndjsonTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+	// The context alone doesn't unblock a stalled body read.
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(ndjsonTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("ndjson read deadline: %v", err)
+	}
+	batchKey := strings.TrimSpace(r.Header.Get("X-Idempotency-Key"))
+	syncAck := wantsSyncAck(r)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	emit := func(v map[string]any) {
+		_ = enc.Encode(v)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	audit := func(n int, key, gwMAC string, status int, dup bool) {
+		auditDecision(auditRecord{Route: r.Pattern, IdemKey: key, GWMAC: gwMAC, Status: status, Dup: dup, Line: n})
+	}
+	n, okCount := 0, 0
+	report := func(line int, key, gwMAC string, res ingestResult) {
+		out := map[string]any{"line": line, "status": res.Status}
+		if res.Err != "" {
+			out["error"] = res.Err
+		} else {
+			for k, v := range res.Body {
+				out[k] = v
+			}
+			okCount++
+		}
+		emit(out)
+		audit(line, key, gwMAC, res.Status, res.Body["dup"] == true)
+	}
+
+	var batch *ndjsonInserts
+	if insertMode && store != nil && !syncAck {
+		batch = &ndjsonInserts{}
+	}
+	flush := func() {
+		if batch == nil {
+			return
+		}
+		for _, p := range batch.flush(r.Context()) {
+			report(p.line, p.key, p.env.GWMAC, p.res)
+		}
+	}
+
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(make([]byte, 0, 4096), ndjsonMaxLine)
+	for sc.Scan() {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		n++
+		if n > ndjsonMaxLines {
+			emit(map[string]any{"line": n, "status": http.StatusRequestEntityTooLarge, "error": fmt.Sprintf("more than %d lines", ndjsonMaxLines)})
+			audit(n, "", "", http.StatusRequestEntityTooLarge, false)
+			break
+		}
+
+		var ln ndjsonLine
+		if err := json.Unmarshal([]byte(text), &ln); err != nil {
+			emit(map[string]any{"line": n, "status": http.StatusBadRequest, "error": "bad json"})
+			audit(n, "", "", http.StatusBadRequest, false)
+			continue
+		}
+		key := strings.TrimSpace(ln.IdempotencyKey)
+		if key == "" && batchKey != "" {
+			key = fmt.Sprintf("%s#%d", batchKey, n)
+		}
+		if key == "" {
+			emit(map[string]any{"line": n, "status": http.StatusBadRequest, "error": "missing idempotency"})
+			audit(n, "", strings.ToUpper(strings.TrimSpace(ln.GWMAC)), http.StatusBadRequest, false)
+			continue
+		}
+
+		if batch != nil && ln.RowID == nil {
+			if gwMAC, res, staged := batch.stage(n, key, ln.Envelope); !staged {
+				report(n, key, gwMAC, res)
+			}
+			if len(batch.pending) >= ndjsonCopyBatch {
+				flush()
+			}
+			continue
+		}
+		// Flushing first keeps results, and receipts, in line order.
+		flush()
+
+		// Each line gets its own entry: the request's belongs to withAudit.
+		ctx, e := withAuditEntry(r.Context())
+		res := processEnvelope(ctx, key, ln.Envelope, syncAck)
+		report(n, key, e.gwMAC, res)
+	}
+	flush()
+	if err := sc.Err(); err != nil {
+		// e.g. bufio.ErrTooLong: the remainder of the stream can't be framed.
+		emit(map[string]any{"line": n + 1, "status": http.StatusBadRequest, "error": "read: " + err.Error()})
+		audit(n+1, "", "", http.StatusBadRequest, false)
+	}
+	log.Printf(`{"event":"ndjson","lines":%d,"ok":%d}`, n, okCount)
+}
+
+// ndjsonInsert is a decoded INSERT_MODE line waiting for the COPY; res is
+// its outcome once flushed.
+type ndjsonInsert struct {
+	line int
+	key  string
+	mac  []byte
+	env  Envelope
+	d    decodedEnvelope
+	res  ingestResult
+}
+
+// ndjsonInserts is the INSERT_MODE batch of one NDJSON request. Lines are
+// held in memory until flush, which takes their receipts and writes their
+// rows in one short tx, so no lock is held while the client streams and a
+// failed COPY leaves every line retryable.
+type ndjsonInserts struct {
+	pending []ndjsonInsert
+}
+
+// stage validates and decodes one line into the batch. A line that stops
+// short (rejected, decode error) is not staged: res is its result, gwMAC
+// the gateway to audit.
+func (b *ndjsonInserts) stage(line int, key string, env Envelope) (gwMAC string, res ingestResult, staged bool) {
+	start := time.Now()
+	mac, res, ok := normalizeEnvelope(&env)
+	if !ok {
+		return env.GWMAC, res, false
+	}
+	d, res, ok := decodeEnvelope(env, start)
+	if !ok {
+		return env.GWMAC, res, false
+	}
+	b.pending = append(b.pending, ndjsonInsert{line: line, key: key, mac: mac, env: env, d: d})
+	return env.GWMAC, ingestResult{}, true
+}
+
+// flush takes the pending receipts, COPYs the rows that aren't dups, points
+// their receipts at the new ids and commits, then publishes each row. It
+// returns the flushed lines with their results and starts a fresh batch.
+func (b *ndjsonInserts) flush(ctx context.Context) []ndjsonInsert {
+	done := b.pending
+	if len(done) == 0 {
+		return nil
+	}
+	b.pending = nil
+	start := time.Now()
+	fail := func(what string, err error) []ndjsonInsert {
+		log.Printf("ndjson %s err (%d rows): %v", what, len(done), err)
+		for i := range done {
+			done[i].res = errResult(http.StatusInternalServerError, "server error")
+		}
+		return done
+	}
+
+	keys := make([]string, len(done))
+	var macs [][]byte
+	for i, p := range done {
+		keys[i] = p.key
+		if idemPerMAC {
+			macs = append(macs, p.mac)
+		}
+	}
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return fail("begin tx", err)
+	}
+	defer tx.Rollback(context.Background())
+	dup, err := tx.InsertReceipts(ctx, macs, keys)
+	if err != nil {
+		return fail("idempotency check", err)
+	}
+
+	var fresh []*ndjsonInsert
+	var rows []storage.InsertRow
+	keys, macs = keys[:0], macs[:0]
+	for i := range done {
+		p := &done[i]
+		if dup[i] {
+			p.res = ingestResult{Status: dupStatus, Body: map[string]any{"ok": true, "dup": true}}
+			continue
+		}
+		fresh = append(fresh, p)
+		rows = append(rows, insertRowFor(p.mac, p.env, p.d))
+		keys = append(keys, p.key)
+		if idemPerMAC {
+			macs = append(macs, p.mac)
+		}
+	}
+	var ids []int64
+	if len(rows) > 0 {
+		if ids, err = tx.CopyInsertParsed(ctx, rows); err == nil {
+			err = tx.SetReceiptRows(ctx, macs, keys, ids)
+		}
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		return fail("copy insert", err)
+	}
+	log.Printf(`{"event":"ndjson_copy","rows":%d,"dups":%d,"took_ms":%d}`, len(fresh), len(done)-len(fresh), time.Since(start).Milliseconds())
+
+	for i, p := range fresh {
+		p.env.RowID = &ids[i]
+		if _, res, ok := publishEnvelope(ctx, p.env, p.d, nil, false); !ok {
+			p.res = res
+			continue
+		}
+		p.res = storedResult(p.d, false, false, &ids[i])
+	}
+	return done
+}
@@ -4,43 +4,250 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"math"
 	"strings"
 	"time"
 )
 
 // Auto is the parsed representation of MKGW4 gateway auto frames.
 type Auto struct {
-	Flag      string      // "3004", "3089", "30b1"
-	Timestamp int64       // seconds (from frame)
-	Hex       string      // full frame hex (uppercase)
-	Status    *AutoStatus // only for 3004
-	Fix       *AutoFix    // only for 3089/30b1
+	Flag          string            // "3004", "3089", "30b1"
+	Timestamp     int64             // seconds (from frame, else decode time)
+	HasFrameTs    bool              // Timestamp came from the frame itself
+	Hex           string            // full frame hex (uppercase)
+	Status        *AutoStatus       // 3004, 30c0
+	Fix           *AutoFix          // 3089/30b1, 30c0 (fix sub-block)
+	Scan          []ScanBeacon      // only for 30a0
+	StatusHistory []StatusSnapshot  // store-and-forward 3004 batches, oldest first (Status is the latest)
+	OTAProgress   *OTAProgress      // only for 30d0
+	CrashDump     string            // only for 30e0, ASCII assert dump
+	Sensors       []SensorReading   // attached BLE sensors (tag 0x22), any flag
+	Warnings      []string          // non-fatal decode issues (parsed["decode_warnings"])
+	Profile       string            // set when an OEM tag profile was detected (parsed["profile"])
+	RawTLVs       map[string]string // "0x10" -> value hex; only with KeepRawTags
+	SeqNo         int               // tag 0x12 (uint16, wraps at 65535); valid when HasSeq
+	HasSeq        bool
 }
 
 type AutoStatus struct {
-	NetworkType string
-	CSQ         int
-	BattmV      int
-	AxisXmg     int
-	AxisYmg     int
-	AxisZmg     int
-	AccStatus   int
-	IMEI        string
-	ICCID       string
+	NetworkType       string
+	CSQ               int
+	BattmV            int
+	AxisXmg           int
+	AxisYmg           int
+	AxisZmg           int
+	AccStatus         int
+	IMEI              string
+	ICCID             string
+	TempC             float64  // tag 0x10, °C
+	Humidity          int      // tag 0x11, %RH
+	BootCount         int64    // tag 0x13
+	UptimeSec         int64    // tag 0x14
+	BattTempC         int      // tag 0x15, °C
+	RSRP              int      // tag 0x18, dBm
+	RSRQ              int      // tag 0x18, dB
+	PLMN              string   // tag 0x19, "MCC-MNC" or operator name
+	ConfigVersion     int      // tag 0x1A, applied OTA config version
+	WakeReason        string   // tag 0x1C, see wakeReasonNames
+	BoardTempC        float64  // tag 0x1D, board (not battery) °C
+	LinkQuality       int      // tag 0x1E, 0-100 cellular health score
+	ReportIntervalSec int      // tag 0x1F, configured reporting interval
+	TamperState       string   // tag 0x20, see tamperStateNames
+	TamperTs          int64    // tag 0x20, unix seconds of the tamper event
+	PendingDownlinks  int      // tag 0x23, queued downlink commands
+	MotionEvents      int      // tag 0x24, motion events since last report
+	MovingSec         int      // tag 0x25, seconds in motion since last report
+	PowerSource       string   // tag 0x26 byte 0, see powerSourceNames
+	ChargingState     string   // tag 0x26 byte 1, see chargingStateNames
+	AxisMagnitude     float64  // from tag 0x04: |(x,y,z)| in mg
+	TiltDegrees       float64  // from tag 0x04: angle off the +Z axis; absent when all axes are 0
+	Present           []string // parsed-JSON keys actually decoded
 }
 
 type AutoFix struct {
-	FixMode   string
-	FixResult string
-	Longitude float64
-	Latitude  float64
-	TacLac    int
-	CI        int64
+	FixMode        string
+	FixResult      string
+	Longitude      float64
+	Latitude       float64
+	TacLac         int
+	CI             int64
+	NeighborCells  []NeighborCell // repeated tag 0x0A
+	DownlinkID     int            // tag 0x16, echoed downlink command id (Downlink mode only)
+	Constellations []string       // tag 0x17 bitfield
+	AccuracyM      int            // tag 0x1B, EHPE in meters
+	LAC            int            // tag 0x04 area code on 2G/3G (see classifyCellArea)
+	TAC            int            // tag 0x04 area code on LTE/NB-IoT/Cat-M
+	AntennaStatus  string         // tag 0x21, see antennaStatusNames
+	Present        []string       // parsed-JSON keys actually decoded
+}
+
+// NeighborCell is one LBS neighbor-cell measurement.
+type NeighborCell struct {
+	CI   int64
+	RSSI int // dBm (signed)
+}
+
+// PresentFields lists the decoded keys as "status.<key>" / "fix.<key>", so
+// consumers can tell an absent field from a real zero.
+func (a *Auto) PresentFields() []string {
+	out := []string{}
+	if a.Status != nil {
+		for _, k := range a.Status.Present {
+			out = append(out, "status."+k)
+		}
+	}
+	if a.Fix != nil {
+		for _, k := range a.Fix.Present {
+			out = append(out, "fix."+k)
+		}
+	}
+	return out
+}
+
+// Has reports whether key was decoded from the frame.
+func (f *AutoFix) Has(key string) bool { return hasKey(f.Present, key) }
+
+// Has reports whether key was decoded from the frame.
+func (st *AutoStatus) Has(key string) bool { return hasKey(st.Present, key) }
+
+func (a *Auto) warn(format string, args ...any) {
+	a.Warnings = append(a.Warnings, fmt.Sprintf(format, args...))
+}
+
+// applyEpochOffset shifts timestamps taken from the frame by off seconds,
+// for firmware counting from another epoch (2000-01-01: 946684800). The
+// decode-time fallback is already Unix time and is left alone.
+func (a *Auto) applyEpochOffset(off int64) {
+	if off == 0 {
+		return
+	}
+	if a.HasFrameTs {
+		a.Timestamp += off
+	}
+	for i := range a.StatusHistory {
+		if a.StatusHistory[i].Ts != 0 {
+			a.StatusHistory[i].Ts += off
+		}
+	}
+	if a.Status != nil && a.Status.Has("tamper_ts") {
+		a.Status.TamperTs += off
+	}
+}
+
+// legacyRATs are network types whose tag 0x04 area code is a LAC.
+var legacyRATs = []string{"GSM", "GPRS", "EDGE", "2G", "UMTS", "WCDMA", "HSPA", "3G"}
+
+// classifyCellArea files the tag 0x04 area code as LAC (2G/3G) or TAC (LTE,
+// NB-IoT, Cat-M) by the frame's network type. Fix-only frames carry no
+// network type and keep the historical TAC reading.
+func (a *Auto) classifyCellArea() {
+	f := a.Fix
+	if f == nil || !f.Has("tac_lac") {
+		return
+	}
+	if a.Status != nil && hasKey(legacyRATs, strings.ToUpper(strings.TrimSpace(a.Status.NetworkType))) {
+		f.LAC = f.TacLac
+		markPresent(&f.Present, "lac")
+		return
+	}
+	f.TAC = f.TacLac
+	markPresent(&f.Present, "tac")
+}
+
+// checkFixResult drops the position when the fix itself failed (timeout,
+// LBS failure, ...): the frame may still carry stale or zero coordinates in
+// tag 0x03. Mode and result are kept so the attempt is still recorded.
+func (a *Auto) checkFixResult() {
+	f := a.Fix
+	if f == nil || !f.Has("lat") || !f.Has("result") || fixSucceeded(f.FixResult) {
+		return
+	}
+	a.warn("fix position dropped: result %q", f.FixResult)
+	f.Latitude, f.Longitude = 0, 0
+	f.Present = dropKeys(f.Present, "lon", "lat")
+}
+
+func fixSucceeded(result string) bool {
+	return result == "GPS fix success" || result == "LBS fix success"
+}
+
+// checkFixBounds drops positions at null island (0,0) or outside the
+// configured bounds, so corrupted LBS data doesn't land on the map.
+func (a *Auto) checkFixBounds() {
+	f := a.Fix
+	if f == nil || !f.Has("lat") {
+		return
+	}
+	o := decodeOpts
+	var why string
+	switch {
+	case f.Latitude == 0 && f.Longitude == 0:
+		why = "null island (0,0)"
+	case f.Latitude < o.MinLat || f.Latitude > o.MaxLat || f.Longitude < o.MinLon || f.Longitude > o.MaxLon:
+		why = "out of bounds"
+	default:
+		return
+	}
+	a.warn("fix position rejected: %s lat=%.7f lon=%.7f", why, f.Latitude, f.Longitude)
+	f.Latitude, f.Longitude = 0, 0
+	f.Present = dropKeys(f.Present, "lon", "lat")
+	f.FixResult = strings.TrimSpace(f.FixResult + " (position rejected)")
+}
+
+// DecodeOptions tunes MKGW4 decoding for firmware variants.
+type DecodeOptions struct {
+	BCDIdentifiers bool       // decode non-printable IMEI/ICCID TLVs as nibble-swapped packed BCD
+	MaxTLVEntries  int        // hard cap on TLV entries walked per frame (<=0: default)
+	TagOffset      byte       // OEM profiles shift tags (e.g. +0x80); tags >= offset are shifted back (0: off)
+	KeepRawTags    bool       // keep each top-level TLV's raw hex (parsed["raw_tlvs"]) for firmware debugging
+	TLVLength      TLVLenMode // what TLV length fields count (TLV_LEN_MODE)
+	StrictFrameLen bool       // a frame header length mismatch is an error, not a warning
+	LengthPrefix   int        // strip an outer N-byte big-endian body length before the TLVs (0: off)
+	SanitizeASCII  bool       // strip non-printable bytes from ASCII TLV strings (with a warning)
+
+	// Plausible fix bounds; positions outside (or exactly 0,0) are rejected.
+	MinLat, MaxLat float64
+	MinLon, MaxLon float64
+}
+
+const defaultMaxTLVEntries = 256
+
+// TLVLenMode selects what a TLV length field counts. One firmware includes
+// the 3-byte tag/len header in it.
+type TLVLenMode int
+
+const (
+	TLVLenValue     TLVLenMode = iota // length covers the value only (default)
+	TLVLenInclusive                   // length includes the 3-byte header
+	TLVLenAuto                        // pick whichever convention walks the frame exactly
+)
+
+// decodeOpts is set once at startup (see main) and read by the TLV parsers.
+var decodeOpts = DecodeOptions{
+	BCDIdentifiers: true,
+	SanitizeASCII:  true,
+	MaxTLVEntries:  defaultMaxTLVEntries,
+	MinLat:         -90,
+	MaxLat:         90,
+	MinLon:         -180,
+	MaxLon:         180,
+}
+
+func maxTLVEntries() int {
+	if decodeOpts.MaxTLVEntries <= 0 {
+		return defaultMaxTLVEntries
+	}
+	return decodeOpts.MaxTLVEntries
 }
 
 // DecodeMKGW4Auto accepts either ASCII-hex or raw bytes (we get hex).
 func DecodeMKGW4Auto(flagHex string, bodyHex string) (*Auto, bool, error) {
+	return decodeMKGW4Auto(flagHex, bodyHex, true)
+}
+
+// decodeMKGW4Auto is DecodeMKGW4Auto; countTags false keeps synthetic decodes
+// (the self-test) out of gwauto_tlv_tags_total.
+func decodeMKGW4Auto(flagHex string, bodyHex string, countTags bool) (*Auto, bool, error) {
 	flag := strings.ToUpper(strings.TrimSpace(flagHex))
 
 	h := strings.ToUpper(strings.TrimSpace(string(bodyHex)))
@@ -51,55 +258,248 @@ func DecodeMKGW4Auto(flagHex string, bodyHex string) (*Auto, bool, error) {
 		return nil, false, fmt.Errorf("hex decode: %w", err)
 	}
 
+	if n := decodeOpts.LengthPrefix; n > 0 {
+		if b, err = stripLengthPrefix(b, n); err != nil {
+			return nil, false, err
+		}
+	}
+	if flag == "" {
+		flag, b = embeddedFlag(b)
+	}
+
 	a := &Auto{Flag: strings.ToLower(flag), Hex: h}
+	if body, declared, ok := stripFrameHeader(b, flag); ok {
+		b = body
+		if declared != len(b) {
+			if decodeOpts.StrictFrameLen {
+				return nil, true, fmt.Errorf("frame length: header says %d, body is %d", declared, len(b))
+			}
+			a.warn("frame length mismatch: header says %d, body is %d", declared, len(b))
+		}
+	}
+	isTLV := !nonTLVFlags[flag]
+	if o := decodeOpts.TagOffset; isTLV && o > 0 && len(b) > 0 && b[0] >= o {
+		a.Profile = fmt.Sprintf("tag+0x%02X", o)
+	}
+	if isTLV && inclusiveTLVLengths(b) {
+		b = valueOnlyTLVLengths(b)
+		a.Profile = strings.TrimPrefix(a.Profile+",len+hdr", ",")
+	}
+	if v, ok := tlvValue(b, 0x12); isTLV && ok && len(v) >= 2 {
+		a.SeqNo, a.HasSeq = be16(v), true
+	}
+	if isTLV {
+		a.checkDuplicateTags(b)
+		if decodeOpts.KeepRawTags {
+			a.RawTLVs = rawTLVs(b)
+		}
+	}
+
+	parse, ok := flagParsers[flag]
+	if !ok {
+		return nil, false, nil
+	}
+	if isTLV && countTags {
+		countTLVTags(flag, b)
+	}
+	p, ts, err := parse(b)
+	if err != nil {
+		return nil, true, err
+	}
+
+	a.Status, a.Fix, a.Scan, a.OTAProgress = p.Status, p.Fix, p.Scan, p.OTAProgress
+	a.StatusHistory, a.CrashDump = p.StatusHistory, p.CrashDump
+	if isTLV {
+		if a.Sensors, err = parseSensors(b); err != nil {
+			a.warn("sensors: %v", err)
+			a.Sensors = nil
+		}
+	}
+	a.classifyCellArea()
+	a.Warnings = append(a.Warnings, p.Warnings...)
+	a.HasFrameTs = ts != 0
+	if ts == 0 {
+		ts = time.Now().Unix()
+	}
+
+	a.Timestamp = ts
+	return a, true, nil
+}
+
+// flagParsers maps an MKGW4 flag to its body parser. A parser returns just
+// the decoded section (Status/Fix/Scan, plus its own warnings) and the frame
+// timestamp (0 if absent); DecodeMKGW4Auto fills in the frame-level fields.
+var flagParsers = map[string]func([]byte) (*Auto, int64, error){}
+
+// RegisterFlagParser adds (or replaces) the body parser for an MKGW4 flag.
+func RegisterFlagParser(flag string, fn func([]byte) (*Auto, int64, error)) {
+	flagParsers[strings.ToUpper(strings.TrimSpace(flag))] = fn
+}
+
+func init() {
+	RegisterFlagParser("3004", statusFrame)
+	RegisterFlagParser("3089", fixFrame)
+	RegisterFlagParser("30B1", fixFrame)
+	RegisterFlagParser("30A0", scanFrame)
+	RegisterFlagParser("30C0", fullReportFrame)
+	RegisterFlagParser("30D0", otaFrame)
+	RegisterFlagParser("30E0", crashFrame)
+	RegisterFlagParser(compactFixFlag, compactFixFrame)
+}
+
+// nonTLVFlags are flags whose body is not TLV (no tag profile, seq,
+// duplicate or sensor handling before dispatch).
+var nonTLVFlags = map[string]bool{"30E0": true, compactFixFlag: true}
+
+// StatusSnapshot is one status block of a store-and-forward batch.
+type StatusSnapshot struct {
+	Ts     int64 // snapshot timestamp (0 if absent)
+	Status *AutoStatus
+}
 
-	switch flag {
-	case "3004":
-		log.Printf("In case 3004")
-		st, ts, err := parseStatusTLV(b)
+// statusSnapshotMarker separates the snapshots of a store-and-forward 3004
+// batch (value ignored).
+const statusSnapshotMarker = 0x31
 
+// statusFrame decodes a 3004 body. A batched frame yields every snapshot in
+// StatusHistory, and the newest one (by timestamp) becomes Status.
+func statusFrame(b []byte) (*Auto, int64, error) {
+	segs := splitAtTag(b, statusSnapshotMarker)
+	a := &Auto{}
+	var ts int64
+	for k, seg := range segs {
+		st, sts, err := parseStatusTLV(seg)
 		if err != nil {
-			return nil, true, err
+			if len(segs) > 1 {
+				err = fmt.Errorf("status snapshot %d: %w", k, err)
+			}
+			return nil, 0, err
+		}
+		a.sanitizeStatus(st)
+		if len(segs) > 1 {
+			a.StatusHistory = append(a.StatusHistory, StatusSnapshot{Ts: sts, Status: st})
 		}
+		if a.Status == nil || sts >= ts {
+			a.Status, ts = st, sts
+		}
+	}
+	a.checkStatus()
+	return a, ts, nil
+}
 
-		a.Status = st
-		if ts == 0 {
-			ts = time.Now().Unix()
+// splitAtTag cuts body into the TLV runs between top-level marker TLVs
+// (markers dropped, empty runs skipped). A malformed tail stays in the last
+// run so the typed parser reports it.
+func splitAtTag(body []byte, marker byte) [][]byte {
+	var segs [][]byte
+	start, i := 0, 0
+	for i+3 <= len(body) {
+		next := i + 3 + be16(body[i+1:])
+		if next > len(body) {
+			break
+		}
+		if normTag(body[i]) == marker {
+			if i > start {
+				segs = append(segs, body[start:i])
+			}
+			start = next
 		}
+		i = next
+	}
+	if start < len(body) || len(segs) == 0 {
+		segs = append(segs, body[start:])
+	}
+	return segs
+}
+
+func fixFrame(b []byte) (*Auto, int64, error) {
+	fx, ts, err := parseFixTLV(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	a := &Auto{Fix: fx}
+	a.checkFixResult()
+	a.checkFixBounds()
+	return a, ts, nil
+}
+
+// compactFixFlag is the downlink-response fix sent as a fixed struct
+// instead of TLVs:
+//
+//	mode (uint8) | result (uint8) | lon (int32, * 1e-7) | lat (int32, * 1e-7) | downlink id (uint16)
+const compactFixFlag = "30B2"
+
+const compactFixLen = 12
 
-		a.Timestamp = ts
-		return a, true, nil
+// compactFixFrame decodes a compactFixFlag body. It carries no timestamp.
+func compactFixFrame(b []byte) (*Auto, int64, error) {
+	if len(b) != compactFixLen {
+		return nil, 0, fmt.Errorf("compact fix: %d bytes, want %d", len(b), compactFixLen)
+	}
+	f := &AutoFix{}
+	if int(b[0]) < len(fixModeNames) {
+		f.FixMode = fixModeNames[b[0]]
+		markPresent(&f.Present, "mode")
+	}
+	if int(b[1]) < len(fixResultNames) {
+		f.FixResult = fixResultNames[b[1]]
+		markPresent(&f.Present, "result")
+	}
+	f.Longitude = float64(int32(be32(b[2:6]))) * 0.0000001
+	f.Latitude = float64(int32(be32(b[6:10]))) * 0.0000001
+	f.DownlinkID = be16(b[10:12])
+	markPresent(&f.Present, "lon", "lat", "downlink_id")
+	a := &Auto{Fix: f}
+	a.checkFixResult()
+	a.checkFixBounds()
+	return a, 0, nil
+}
 
-	case "3089", "30B1":
-		log.Printf("In case 3089/30B1")
-		fx, ts, err := parseFixTLV(b)
+// fullReportSubFix is the 30C0 tag whose value is a complete fix TLV body.
+const fullReportSubFix = 0x30
 
+// fullReportFrame decodes the consolidated 30C0 report: status TLVs at the
+// top level plus an optional fix sub-block. The status timestamp wins; the
+// sub-block's is used only when the status one is missing.
+func fullReportFrame(b []byte) (*Auto, int64, error) {
+	a, ts, err := statusFrame(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v, ok := tlvValue(b, fullReportSubFix); ok {
+		f, fts, err := fixFrame(v)
 		if err != nil {
-			return nil, true, err
+			return nil, 0, fmt.Errorf("30c0 fix block: %w", err)
 		}
-
-		a.Fix = fx
+		a.Fix = f.Fix
+		a.Warnings = append(a.Warnings, f.Warnings...)
 		if ts == 0 {
-			ts = time.Now().Unix()
+			ts = fts
 		}
+	}
+	return a, ts, nil
+}
 
-		a.Timestamp = ts
-		return a, true, nil
-
-	default:
-		return nil, false, nil
+func scanFrame(b []byte) (*Auto, int64, error) {
+	beacons, ts, err := parseScanTLV(b)
+	if err != nil {
+		return nil, 0, err
 	}
+	return &Auto{Scan: beacons}, ts, nil
 }
 
 func parseStatusTLV(body []byte) (*AutoStatus, int64, error) {
 	st := &AutoStatus{}
 	var ts int64
-	i := 0
+	i, n, maxN := 0, 0, maxTLVEntries()
 	for i < len(body) {
+		if n++; n > maxN {
+			return nil, 0, fmt.Errorf("status tlv: more than %d entries", maxN)
+		}
 		if i+3 > len(body) {
 			return nil, 0, errors.New("status tlv len OOB")
 		}
-		tag := body[i]
+		tag := normTag(body[i])
 		i++
 		ln := be16(body[i:])
 		i += 2
@@ -113,28 +513,127 @@ func parseStatusTLV(body []byte) (*AutoStatus, int64, error) {
 			}
 		case 0x01: // network type (ASCII)
 			st.NetworkType = string(body[i : i+ln])
+			markPresent(&st.Present, "network_type")
 		case 0x02: // csq
 			if ln >= 1 {
 				st.CSQ = int(body[i])
+				markPresent(&st.Present, "csq")
 			}
 		case 0x03: // batt mV (2B)
 			if ln >= 2 {
 				st.BattmV = be16(body[i : i+2])
+				markPresent(&st.Present, "batt_mv")
 			}
-		case 0x04: // axis x/y/z
+		case 0x04: // axis x/y/z (int8 each: a device upside down reads z < 0)
 			if ln >= 3 {
-				st.AxisXmg = int(body[i+0])
-				st.AxisYmg = int(body[i+1])
-				st.AxisZmg = int(body[i+2])
+				st.AxisXmg = int(int8(body[i+0]))
+				st.AxisYmg = int(int8(body[i+1]))
+				st.AxisZmg = int(int8(body[i+2]))
+				markPresent(&st.Present, "axis_x_mg", "axis_y_mg", "axis_z_mg")
+				st.setAxisOrientation()
 			}
 		case 0x05: // acc status
 			if ln >= 1 {
 				st.AccStatus = int(body[i])
+				markPresent(&st.Present, "acc_status")
+			}
+		case 0x06: // IMEI (ASCII, or BCD on some firmware)
+			st.IMEI = asciiOrBCD(body[i : i+ln])
+			markPresent(&st.Present, "imei")
+		case 0x07: // ICCID (ASCII, or BCD on some firmware)
+			st.ICCID = asciiOrBCD(body[i : i+ln])
+			markPresent(&st.Present, "iccid")
+		case 0x10: // temperature (int16, * 0.1 °C)
+			if ln >= 2 {
+				st.TempC = float64(int16(be16(body[i:i+2]))) / 10
+				markPresent(&st.Present, "temp_c")
+			}
+		case 0x11: // humidity (uint8, %)
+			if ln >= 1 {
+				st.Humidity = int(body[i])
+				markPresent(&st.Present, "humidity")
+			}
+		case 0x13: // boot count (uint32)
+			if ln >= 4 {
+				st.BootCount = be32(body[i : i+4])
+				markPresent(&st.Present, "boot_count")
+			}
+		case 0x14: // uptime seconds (uint32)
+			if ln >= 4 {
+				st.UptimeSec = be32(body[i : i+4])
+				markPresent(&st.Present, "uptime_sec")
+			}
+		case 0x15: // battery temperature (int8, °C)
+			if ln >= 1 {
+				st.BattTempC = int(int8(body[i]))
+				markPresent(&st.Present, "batt_temp_c")
+			}
+		case 0x18: // serving cell RSRP (int16 dBm) + RSRQ (int8 dB)
+			if ln >= 3 {
+				st.RSRP = int(int16(be16(body[i : i+2])))
+				st.RSRQ = int(int8(body[i+2]))
+				markPresent(&st.Present, "rsrp", "rsrq")
+			}
+		case 0x19: // serving PLMN (3-byte BCD MCC/MNC, or ASCII operator name)
+			if ln >= 1 {
+				st.PLMN = plmnString(body[i : i+ln])
+				markPresent(&st.Present, "plmn")
+			}
+		case 0x1A: // applied config version (uint16)
+			if ln >= 2 {
+				st.ConfigVersion = be16(body[i : i+2])
+				markPresent(&st.Present, "config_version")
+			}
+		case 0x1C: // wake reason (uint8 code)
+			if ln >= 1 {
+				st.WakeReason = enumName(wakeReasonNames, body[i])
+				markPresent(&st.Present, "wake_reason")
+			}
+		case 0x1D: // board temperature (int16, * 0.1 °C)
+			if ln >= 2 {
+				st.BoardTempC = float64(int16(be16(body[i:i+2]))) / 10
+				markPresent(&st.Present, "board_temp_c")
+			}
+		case 0x1E: // link quality score (uint8, 0-100)
+			if ln >= 1 {
+				st.LinkQuality = int(body[i])
+				markPresent(&st.Present, "link_quality")
+			}
+		case 0x1F: // reporting interval seconds (uint16)
+			if ln >= 2 {
+				st.ReportIntervalSec = be16(body[i : i+2])
+				markPresent(&st.Present, "report_interval_sec")
+			}
+		case 0x20: // tamper event: state (uint8) + event timestamp (uint32)
+			if ln >= 5 {
+				st.TamperState = enumName(tamperStateNames, body[i])
+				st.TamperTs = be32(body[i+1 : i+5])
+				markPresent(&st.Present, "tamper_state", "tamper_ts")
+			}
+		case 0x23: // pending downlink commands (uint8)
+			if ln >= 1 {
+				st.PendingDownlinks = int(body[i])
+				markPresent(&st.Present, "pending_downlinks")
+			}
+		case 0x24: // motion event count since last report (uint16)
+			if ln >= 2 {
+				st.MotionEvents = be16(body[i : i+2])
+				markPresent(&st.Present, "motion_events")
+			}
+		case 0x25: // seconds in motion since last report (uint16)
+			if ln >= 2 {
+				st.MovingSec = be16(body[i : i+2])
+				markPresent(&st.Present, "moving_sec")
+			}
+		case 0x26: // power source (uint8) [+ charging state (uint8)]
+			if ln >= 1 {
+				st.PowerSource = enumName(powerSourceNames, body[i])
+				markPresent(&st.Present, "power_source")
+			}
+			if ln >= 2 {
+				st.ChargingState = enumName(chargingStateNames, body[i+1])
+				markPresent(&st.Present, "charging_state")
 			}
-		case 0x06: // IMEI (ASCII)
-			st.IMEI = string(body[i : i+ln])
-		case 0x07:
-			st.ICCID = string(body[i : i+ln])
 		}
 		i += ln
 	}
@@ -148,15 +647,58 @@ var fixResultNames = []string{
 	"GPS serial port is used", "GPS aiding timeout", "GPS timeout", "PDOP limit", "LBS failure",
 }
 
+// tamperStateNames maps the tag 0x20 state byte; anything but "closed" is
+// tamper-positive.
+var tamperStateNames = []string{"closed", "open"}
+
+// Tampered reports a tamper-positive status frame.
+func (st *AutoStatus) Tampered() bool {
+	return st != nil && st.Has("tamper_state") && st.TamperState != tamperStateNames[0]
+}
+
+// powerSourceNames / chargingStateNames map the two tag 0x26 bytes. On
+// external or solar power batt_mv is the backup cell, not the supply.
+var (
+	powerSourceNames   = []string{"battery", "external", "solar"}
+	chargingStateNames = []string{"not_charging", "charging", "full"}
+)
+
+// enumName looks c up in names, "unknown(c)" when out of range.
+func enumName(names []string, c byte) string {
+	if int(c) < len(names) {
+		return names[c]
+	}
+	return fmt.Sprintf("unknown(%d)", c)
+}
+
+// wakeReasonNames maps tag 0x1C codes; more granular than fix mode.
+var wakeReasonNames = []string{"timer", "accelerometer", "downlink", "external"}
+
+// constellationBits maps tag 0x17 bits (LSB first) to constellation names.
+var constellationBits = []string{"GPS", "GLONASS", "Galileo", "BeiDou"}
+
+func constellationNames(bits byte) []string {
+	out := []string{}
+	for i, name := range constellationBits {
+		if bits&(1<<i) != 0 {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
 func parseFixTLV(body []byte) (*AutoFix, int64, error) {
 	f := &AutoFix{}
 	var ts int64
-	i := 0
+	i, n, maxN := 0, 0, maxTLVEntries()
 	for i < len(body) {
+		if n++; n > maxN {
+			return nil, 0, fmt.Errorf("fix tlv: more than %d entries", maxN)
+		}
 		if i+3 > len(body) {
 			return nil, 0, errors.New("fix tlv len OOB")
 		}
-		tag := body[i]
+		tag := normTag(body[i])
 		i++
 		ln := be16(body[i:])
 		i += 2
@@ -173,6 +715,7 @@ func parseFixTLV(body []byte) (*AutoFix, int64, error) {
 				idx := int(body[i])
 				if idx >= 0 && idx < len(fixModeNames) {
 					f.FixMode = fixModeNames[idx]
+					markPresent(&f.Present, "mode")
 				}
 			}
 		case 0x02: // fix result
@@ -180,14 +723,20 @@ func parseFixTLV(body []byte) (*AutoFix, int64, error) {
 				idx := int(body[i])
 				if idx >= 0 && idx < len(fixResultNames) {
 					f.FixResult = fixResultNames[idx]
+					markPresent(&f.Present, "result")
 				}
 			}
-		case 0x03: // lon/lat (int32 each, * 1e-7)
+		case 0x03: // lon/lat (int32 each, * 1e-7; compact firmware: int24 each, * 1e-5)
 			if ln >= 8 {
 				lon := int32(body[i])<<24 | int32(body[i+1])<<16 | int32(body[i+2])<<8 | int32(body[i+3])
 				lat := int32(body[i+4])<<24 | int32(body[i+5])<<16 | int32(body[i+6])<<8 | int32(body[i+7])
 				f.Longitude = float64(int32(lon)) * 0.0000001
 				f.Latitude = float64(int32(lat)) * 0.0000001
+				markPresent(&f.Present, "lon", "lat")
+			} else if ln == 6 {
+				f.Longitude = float64(be24s(body[i:i+3])) * 0.00001
+				f.Latitude = float64(be24s(body[i+3:i+6])) * 0.00001
+				markPresent(&f.Present, "lon", "lat")
 			}
 		case 0x04: // tac/lac + ci (simplified extraction)
 			if ln >= 6 {
@@ -195,6 +744,35 @@ func parseFixTLV(body []byte) (*AutoFix, int64, error) {
 				tac := int(body[i+4])<<8 | int(body[i+5])
 				f.CI = ci
 				f.TacLac = tac
+				markPresent(&f.Present, "tac_lac", "ci")
+			}
+		case 0x0A: // neighbor cell: ci (4B) + rssi (int8), repeated
+			if ln >= 5 {
+				f.NeighborCells = append(f.NeighborCells, NeighborCell{
+					CI:   be32(body[i : i+4]),
+					RSSI: int(int8(body[i+4])),
+				})
+				markPresent(&f.Present, "neighbors")
+			}
+		case 0x16: // downlink command id (uint16)
+			if ln >= 2 {
+				f.DownlinkID = be16(body[i : i+2])
+				markPresent(&f.Present, "downlink_id")
+			}
+		case 0x17: // constellations used (bitfield)
+			if ln >= 1 {
+				f.Constellations = constellationNames(body[i])
+				markPresent(&f.Present, "constellations")
+			}
+		case 0x1B: // EHPE / horizontal accuracy (uint16 m)
+			if ln >= 2 {
+				f.AccuracyM = be16(body[i : i+2])
+				markPresent(&f.Present, "accuracy_m")
+			}
+		case 0x21: // GPS antenna status (uint8 enum)
+			if ln >= 1 {
+				f.AntennaStatus = enumName(antennaStatusNames, body[i])
+				markPresent(&f.Present, "antenna_status")
 			}
 		}
 		i += ln
@@ -202,6 +780,344 @@ func parseFixTLV(body []byte) (*AutoFix, int64, error) {
 	return f, ts, nil
 }
 
+// antennaStatusNames maps the tag 0x21 GPS antenna check; "open" and
+// "short" mean a site visit.
+var antennaStatusNames = []string{"ok", "open", "short"}
+
+// normTag maps an OEM-shifted tag back onto the standard tag space.
+func normTag(t byte) byte {
+	if o := decodeOpts.TagOffset; o > 0 && t >= o {
+		return t - o
+	}
+	return t
+}
+
+// walkTLV calls fn for each top-level tag/value until fn returns false. It is
+// lenient (stops quietly at a malformed entry); the typed parsers report errors.
+func walkTLV(body []byte, fn func(tag byte, v []byte) bool) {
+	i, n, maxN := 0, 0, maxTLVEntries()
+	for i+3 <= len(body) {
+		if n++; n > maxN {
+			return
+		}
+		tag := normTag(body[i])
+		ln := be16(body[i+1:])
+		i += 3
+		if i+ln > len(body) {
+			return
+		}
+		if !fn(tag, body[i:i+ln]) {
+			return
+		}
+		i += ln
+	}
+}
+
+// stripLengthPrefix removes an n-byte big-endian length that must equal the
+// number of bytes after it.
+func stripLengthPrefix(b []byte, n int) ([]byte, error) {
+	if len(b) < n {
+		return nil, fmt.Errorf("length prefix: frame shorter than %d bytes", n)
+	}
+	declared := 0
+	for _, c := range b[:n] {
+		declared = declared<<8 | int(c)
+	}
+	if declared != len(b)-n {
+		return nil, fmt.Errorf("length prefix: says %d, body is %d", declared, len(b)-n)
+	}
+	return b[n:], nil
+}
+
+// flagSentinel is the raw (never tag-offset) first-TLV tag some firmware uses
+// to carry the flag in the body when the envelope has none.
+const flagSentinel = 0xFF
+
+// embeddedFlag extracts a leading FF 0002 <flag> TLV and returns the flag
+// ("3004") and the body after it; otherwise "" and b unchanged.
+func embeddedFlag(b []byte) (string, []byte) {
+	if len(b) < 5 || b[0] != flagSentinel || be16(b[1:3]) != 2 {
+		return "", b
+	}
+	return fmt.Sprintf("%02X%02X", b[3], b[4]), b[5:]
+}
+
+// frameHeaderLen is magic (1) + flag (2) + body length (uint16).
+const frameHeaderLen = 5
+
+// stripFrameHeader detects an optional EF30-style header (a HEADER_MAGIC byte
+// followed by the frame's own flag) and returns the TLV body after it with
+// the declared body length. Requiring the flag to match keeps a TLV body
+// that merely starts with a magic byte from being mistaken for a header.
+func stripFrameHeader(b []byte, flag string) (body []byte, declared int, ok bool) {
+	if len(b) < frameHeaderLen || !hasKey(headerMagics, fmt.Sprintf("%02X", b[0])) ||
+		fmt.Sprintf("%02X%02X", b[1], b[2]) != flag {
+		return b, 0, false
+	}
+	return b[frameHeaderLen:], be16(b[3:5]), true
+}
+
+// inclusiveTLVLengths reports whether the frame's TLV lengths include the
+// header, per decodeOpts.TLVLength. Auto only switches when the value-only
+// walk doesn't end exactly on the frame boundary but the inclusive one does.
+func inclusiveTLVLengths(body []byte) bool {
+	switch decodeOpts.TLVLength {
+	case TLVLenInclusive:
+		return true
+	case TLVLenAuto:
+		return !tlvWalkExact(body, 0) && tlvWalkExact(body, 3)
+	}
+	return false
+}
+
+// tlvWalkExact walks top-level TLVs with hdr subtracted from each length
+// and reports whether it lands exactly on len(body).
+func tlvWalkExact(body []byte, hdr int) bool {
+	i := 0
+	for i+3 <= len(body) {
+		ln := be16(body[i+1:])
+		if ln < hdr {
+			return false
+		}
+		i += 3 + ln - hdr
+	}
+	return i == len(body)
+}
+
+// valueOnlyTLVLengths rewrites header-inclusive lengths to value-only so
+// the TLV parsers see the usual layout. body is not modified.
+func valueOnlyTLVLengths(body []byte) []byte {
+	out := append([]byte(nil), body...)
+	for i := 0; i+3 <= len(out); {
+		ln := be16(out[i+1:])
+		if ln < 3 {
+			break
+		}
+		out[i+1], out[i+2] = byte((ln-3)>>8), byte(ln-3)
+		i += ln
+	}
+	return out
+}
+
+// tlvValue returns the value of the first top-level TLV with the given tag,
+// for frame-level tags that appear regardless of flag.
+func tlvValue(body []byte, want byte) (val []byte, found bool) {
+	walkTLV(body, func(tag byte, v []byte) bool {
+		if tag == want {
+			val, found = v, true
+			return false
+		}
+		return true
+	})
+	return val, found
+}
+
+// rawTLVs maps each top-level tag to its value hex; repeated tags are
+// joined with ",".
+func rawTLVs(body []byte) map[string]string {
+	out := map[string]string{}
+	walkTLV(body, func(tag byte, v []byte) bool {
+		k := fmt.Sprintf("0x%02X", tag)
+		if prev, ok := out[k]; ok {
+			out[k] = prev + "," + strings.ToUpper(hex.EncodeToString(v))
+		} else {
+			out[k] = strings.ToUpper(hex.EncodeToString(v))
+		}
+		return true
+	})
+	return out
+}
+
+// repeatableTags may legitimately appear more than once in a frame.
+var repeatableTags = map[byte]bool{0x0A: true, 0x0B: true, sensorContainerTag: true}
+
+// checkDuplicateTags warns when a single-valued tag repeats (last one wins).
+// Each snapshot of a batched status frame is checked on its own.
+func (a *Auto) checkDuplicateTags(body []byte) {
+	seen := map[byte]bool{}
+	walkTLV(body, func(tag byte, _ []byte) bool {
+		if tag == statusSnapshotMarker {
+			seen = map[byte]bool{}
+			return true
+		}
+		if seen[tag] && !repeatableTags[tag] {
+			a.warn("duplicate tag 0x%02X", tag)
+		}
+		seen[tag] = true
+		return true
+	})
+}
+
+// setAxisOrientation derives magnitude and tilt from the axis readings. An
+// all-zero vector has magnitude 0 and no defined tilt.
+func (st *AutoStatus) setAxisOrientation() {
+	x, y, z := float64(st.AxisXmg), float64(st.AxisYmg), float64(st.AxisZmg)
+	st.AxisMagnitude = math.Sqrt(x*x + y*y + z*z)
+	markPresent(&st.Present, "axis_magnitude")
+	if st.AxisMagnitude == 0 {
+		return
+	}
+	st.TiltDegrees = math.Acos(z/st.AxisMagnitude) * 180 / math.Pi
+	markPresent(&st.Present, "tilt_deg")
+}
+
+// Plausible battery range for the single Li-ion cell, in mV.
+const minBattmV, maxBattmV = 2000, 5000
+
+// checkStatus records warnings for values that decoded but look wrong.
+func (a *Auto) checkStatus() {
+	st := a.Status
+	if st == nil {
+		return
+	}
+	if st.Has("batt_mv") && (st.BattmV < minBattmV || st.BattmV > maxBattmV) {
+		a.warn("implausible battery %d mV", st.BattmV)
+	}
+	if st.Has("imei") && !validIMEI(st.IMEI) {
+		a.warn("invalid IMEI %q", st.IMEI)
+	}
+}
+
+// validIMEI checks 15 digits with a valid Luhn check digit.
+func validIMEI(s string) bool {
+	if len(s) != 15 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 15; i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// asciiOrBCD returns b as text, falling back to packed BCD when b holds
+// non-printable bytes and BCD decoding is enabled.
+func asciiOrBCD(b []byte) string {
+	if !decodeOpts.BCDIdentifiers || printableASCII(b) {
+		return string(b)
+	}
+	if d, ok := swappedBCD(b); ok {
+		return d
+	}
+	return string(b)
+}
+
+// plmnString formats a 3-byte TS 24.008 PLMN as "MCC-MNC"; anything else
+// (typically an ASCII operator name) is returned as-is.
+func plmnString(b []byte) string {
+	if len(b) != 3 || printableASCII(b) {
+		return string(b)
+	}
+	d := [6]byte{b[0] & 0x0F, b[0] >> 4, b[1] & 0x0F, b[2] & 0x0F, b[2] >> 4, b[1] >> 4}
+	var sb strings.Builder
+	for k, n := range d {
+		switch {
+		case k == 5 && n == 0x0F:
+			// 2-digit MNC
+		case n > 9:
+			return string(b)
+		default:
+			if k == 3 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte('0' + n)
+		}
+	}
+	return sb.String()
+}
+
+// sanitizeStatus strips non-printable bytes from the string TLVs of st:
+// buffer-reuse bugs in the firmware leave NULs and control bytes behind.
+func (a *Auto) sanitizeStatus(st *AutoStatus) {
+	if !decodeOpts.SanitizeASCII {
+		return
+	}
+	for _, f := range []struct {
+		key string
+		v   *string
+	}{
+		{"network_type", &st.NetworkType},
+		{"imei", &st.IMEI},
+		{"iccid", &st.ICCID},
+		{"plmn", &st.PLMN},
+	} {
+		clean := strings.Map(func(r rune) rune {
+			if r < 0x20 || r > 0x7E {
+				return -1
+			}
+			return r
+		}, *f.v)
+		if clean != *f.v {
+			a.warn("%s: stripped %d non-printable bytes", f.key, len(*f.v)-len(clean))
+			*f.v = clean
+		}
+	}
+}
+
+func printableASCII(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// swappedBCD decodes SIM-style BCD: low nibble is the first digit, 0xF is padding.
+func swappedBCD(b []byte) (string, bool) {
+	var sb strings.Builder
+	for _, c := range b {
+		for _, n := range [2]byte{c & 0x0F, c >> 4} {
+			switch {
+			case n <= 9:
+				sb.WriteByte('0' + n)
+			case n == 0x0F:
+				// padding
+			default:
+				return "", false
+			}
+		}
+	}
+	return sb.String(), sb.Len() > 0
+}
+
+func markPresent(present *[]string, keys ...string) {
+	for _, k := range keys {
+		if !hasKey(*present, k) {
+			*present = append(*present, k)
+		}
+	}
+}
+
+func dropKeys(keys []string, drop ...string) []string {
+	out := keys[:0]
+	for _, k := range keys {
+		if !hasKey(drop, k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func hasKey(keys []string, k string) bool {
+	for _, v := range keys {
+		if v == k {
+			return true
+		}
+	}
+	return false
+}
+
 func onlyHex(s string) bool {
 	for i := 0; i < len(s); i++ {
 		c := s[i]
@@ -214,4 +1130,7 @@ func onlyHex(s string) bool {
 
 func be16(b []byte) int { return int(b[0])<<8 | int(b[1]) }
 
+// be24s reads a big-endian two's-complement int24.
+func be24s(b []byte) int32 { return int32(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8) >> 8 }
+
 func be32(b []byte) int64 { return int64(b[0])<<24 | int64(b[1])<<16 | int64(b[2])<<8 | int64(b[3]) }
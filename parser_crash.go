@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// crashFrame decodes a 30E0 firmware assert dump. The body is ASCII text
+// (reason + stack), not TLV, so DecodeMKGW4Auto skips its TLV preprocessing
+// for this flag (see nonTLVFlags). Control bytes other than newline/tab are
+// dropped; the frame has no timestamp of its own.
+func crashFrame(b []byte) (*Auto, int64, error) {
+	a := &Auto{}
+	raw := strings.TrimRight(string(b), "\x00")
+	dump := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || (r >= 0x20 && r <= 0x7E) {
+			return r
+		}
+		return -1
+	}, raw)
+	if len(dump) != len(raw) {
+		a.warn("crash dump: dropped %d non-printable bytes", utf8.RuneCountInString(raw)-utf8.RuneCountInString(dump))
+	}
+	a.CrashDump = strings.TrimSpace(dump)
+	return a, 0, nil
+}
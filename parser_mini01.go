@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// mini01Frame is the MKGWMINI01 self-report JSON, e.g.
+//
+//	{"msg_id":3004,"device_info":{"mac":"CCE01BA20624"},
+//	 "data":{"timestamp":1718000000,"net_type":"WIFI","csq":24,"battery_voltage":3950,...}}
+//
+// Only the fields we denormalize are mapped; the rest stays in the raw JSON.
+type mini01Frame struct {
+	MsgID      json.Number `json:"msg_id"` // number or numeric string
+	DeviceInfo struct {
+		MAC string `json:"mac"`
+	} `json:"device_info"`
+	Data struct {
+		Timestamp json.Number `json:"timestamp"` // s or ms
+		NetType   string      `json:"net_type"`
+		CSQ       *int        `json:"csq"`
+		BattmV    *int        `json:"battery_voltage"`
+		IMEI      string      `json:"imei"`
+		ICCID     string      `json:"iccid"`
+		Longitude *float64    `json:"longitude"`
+		Latitude  *float64    `json:"latitude"`
+		CI        json.Number `json:"ci"` // number or numeric string; kept exact past 2^53
+		TAC       json.Number `json:"tac"`
+	} `json:"data"`
+}
+
+// jsonInt reads an integer JSON number without a float64 round trip. Float
+// forms ("2.68e8") are accepted only when integral and below 2^63
+// (float64(MaxInt64) rounds up to 2^63, hence >=).
+func jsonInt(n json.Number) (int64, error) {
+	if v, err := n.Int64(); err == nil {
+		return v, nil
+	}
+	f, err := n.Float64()
+	if err != nil || f != math.Trunc(f) || math.Abs(f) >= math.MaxInt64 {
+		return 0, fmt.Errorf("not an integer: %q", n)
+	}
+	return int64(f), nil
+}
+
+// jsonFloatInt is jsonInt under JSON_NUMBERS=float: the value goes through
+// float64 first, as json's default decoding would, so integers past 2^53
+// round.
+func jsonFloatInt(n json.Number) (int64, error) {
+	f, err := n.Float64()
+	if err != nil {
+		return 0, fmt.Errorf("not an integer: %q", n)
+	}
+	return jsonInt(json.Number(strconv.FormatFloat(f, 'f', -1, 64)))
+}
+
+// DecodeMKGWMini01 maps a MKGWMINI01 self-frame into Status/Fix. ok is false
+// when the body carries none of the fields we know.
+func DecodeMKGWMini01(jsonBody []byte) (*Auto, bool, error) {
+	var f mini01Frame
+	dec := json.NewDecoder(bytes.NewReader(jsonBody))
+	dec.UseNumber()
+	if err := dec.Decode(&f); err != nil {
+		return nil, false, fmt.Errorf("mini01 json: %w", err)
+	}
+	d := f.Data
+	num := jsonInt
+	if jsonFloatNumbers {
+		num = jsonFloatInt
+	}
+
+	a := &Auto{Flag: "0", Hex: string(jsonBody)}
+	if f.MsgID != "" {
+		if id, err := num(f.MsgID); err != nil {
+			a.warn("mini01 msg_id: %v", err)
+		} else {
+			a.Flag = strconv.FormatInt(id, 10)
+		}
+	}
+	if d.Timestamp != "" {
+		ts, err := num(d.Timestamp)
+		if err != nil {
+			return nil, false, fmt.Errorf("mini01 timestamp: %w", err)
+		}
+		a.Timestamp = ts
+	}
+	if a.Timestamp > 1e12 { // ms
+		a.Timestamp /= 1000
+	}
+	a.HasFrameTs = a.Timestamp != 0
+
+	st := &AutoStatus{}
+	if d.NetType != "" {
+		st.NetworkType = d.NetType
+		markPresent(&st.Present, "network_type")
+	}
+	if d.CSQ != nil {
+		st.CSQ = *d.CSQ
+		markPresent(&st.Present, "csq")
+	}
+	if d.BattmV != nil {
+		st.BattmV = *d.BattmV
+		markPresent(&st.Present, "batt_mv")
+	}
+	if d.IMEI != "" {
+		st.IMEI = d.IMEI
+		markPresent(&st.Present, "imei")
+	}
+	if d.ICCID != "" {
+		st.ICCID = d.ICCID
+		markPresent(&st.Present, "iccid")
+	}
+	if len(st.Present) > 0 {
+		a.Status = st
+	}
+
+	if d.Longitude != nil && d.Latitude != nil {
+		a.Fix = &AutoFix{Longitude: *d.Longitude, Latitude: *d.Latitude}
+		markPresent(&a.Fix.Present, "lon", "lat")
+		a.checkFixBounds()
+	}
+	if d.CI != "" {
+		if ci, err := num(d.CI); err != nil {
+			a.warn("mini01 ci: %v", err)
+		} else {
+			if a.Fix == nil {
+				a.Fix = &AutoFix{}
+			}
+			a.Fix.CI = ci
+			markPresent(&a.Fix.Present, "ci")
+			if tac, err := num(d.TAC); err == nil {
+				a.Fix.TacLac = int(tac)
+				markPresent(&a.Fix.Present, "tac_lac")
+			}
+		}
+	}
+
+	if a.Status == nil && a.Fix == nil {
+		return nil, false, nil
+	}
+	return a, true, nil
+}
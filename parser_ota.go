@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OTAProgress is a 30D0 firmware-update progress report.
+type OTAProgress struct {
+	Percent int // 0-100
+	Error   int // 0 = ok, else firmware-specific error code
+}
+
+// parseOTATLV parses a 30D0 body: tag 0x00 timestamp (uint32), 0x01 percent
+// (uint8), 0x02 error code (uint8).
+func parseOTATLV(body []byte) (*OTAProgress, int64, error) {
+	p := &OTAProgress{}
+	var ts int64
+	i, n, maxN := 0, 0, maxTLVEntries()
+	for i < len(body) {
+		if n++; n > maxN {
+			return nil, 0, fmt.Errorf("ota tlv: more than %d entries", maxN)
+		}
+		if i+3 > len(body) {
+			return nil, 0, errors.New("ota tlv len OOB")
+		}
+		tag := normTag(body[i])
+		i++
+		ln := be16(body[i:])
+		i += 2
+		if i+ln > len(body) {
+			return nil, 0, errors.New("ota tlv OOB")
+		}
+		switch tag {
+		case 0x00: // timestamp
+			if ln >= 4 {
+				ts = be32(body[i : i+4])
+			}
+		case 0x01: // percent complete
+			if ln >= 1 {
+				p.Percent = int(body[i])
+			}
+		case 0x02: // error code
+			if ln >= 1 {
+				p.Error = int(body[i])
+			}
+		}
+		i += ln
+	}
+	return p, ts, nil
+}
+
+func otaFrame(b []byte) (*Auto, int64, error) {
+	p, ts, err := parseOTATLV(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	a := &Auto{OTAProgress: p}
+	if p.Percent > 100 {
+		a.warn("ota percent %d out of range", p.Percent)
+	}
+	return a, ts, nil
+}
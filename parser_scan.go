@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ScanBeacon is one BLE advertiser reported in a 30A0 scan frame.
+type ScanBeacon struct {
+	MAC         string         // uppercase hex, no separators
+	RSSI        int            // dBm (signed)
+	ServiceData string         // advertising service data, uppercase hex ("" when none)
+	Sensor      map[string]any // decoded service data, known formats only (see decodeServiceData)
+}
+
+// minScanEntry is MAC (6) + RSSI (1) + service-data length (1).
+const minScanEntry = 8
+
+// parseScanTLV parses a 30A0 scan body: tag 0x00 timestamp (uint32) and
+// tag 0x0B beacon lists. A list packs variable-length entries back to back:
+//
+//	MAC (6) | RSSI (int8) | svc_len (1) | service data (svc_len)
+//
+// Tag 0x0B may repeat when the gateway splits a long scan.
+func parseScanTLV(body []byte) ([]ScanBeacon, int64, error) {
+	beacons := []ScanBeacon{}
+	var ts int64
+	i, n, maxN := 0, 0, maxTLVEntries()
+	for i < len(body) {
+		if n++; n > maxN {
+			return nil, 0, fmt.Errorf("scan tlv: more than %d entries", maxN)
+		}
+		if i+3 > len(body) {
+			return nil, 0, errors.New("scan tlv len OOB")
+		}
+		tag := normTag(body[i])
+		i++
+		ln := be16(body[i:])
+		i += 2
+		if i+ln > len(body) {
+			return nil, 0, errors.New("scan tlv OOB")
+		}
+		switch tag {
+		case 0x00: // timestamp
+			if ln >= 4 {
+				ts = be32(body[i : i+4])
+			}
+		case 0x0B: // beacon list
+			list, err := parseScanList(body[i : i+ln])
+			if err != nil {
+				return nil, 0, err
+			}
+			beacons = append(beacons, list...)
+		}
+		i += ln
+	}
+	return beacons, ts, nil
+}
+
+func parseScanList(v []byte) ([]ScanBeacon, error) {
+	var out []ScanBeacon
+	for j := 0; j < len(v); {
+		if j+minScanEntry > len(v) {
+			return nil, errors.New("scan entry OOB")
+		}
+		svcLen := int(v[j+7])
+		if j+minScanEntry+svcLen > len(v) {
+			return nil, errors.New("scan service data OOB")
+		}
+		svc := v[j+minScanEntry : j+minScanEntry+svcLen]
+		out = append(out, ScanBeacon{
+			MAC:         strings.ToUpper(hex.EncodeToString(v[j : j+6])),
+			RSSI:        int(int8(v[j+6])),
+			ServiceData: strings.ToUpper(hex.EncodeToString(svc)),
+			Sensor:      decodeServiceData(svc),
+		})
+		j += minScanEntry + svcLen
+	}
+	return out, nil
+}
+
+// decodeServiceData understands Eddystone-TLM (UUID 0xFEAA, frame 0x20);
+// anything else is left to consumers as hex.
+func decodeServiceData(svc []byte) map[string]any {
+	if len(svc) < 16 || svc[0] != 0xAA || svc[1] != 0xFE || svc[2] != 0x20 {
+		return nil
+	}
+	return map[string]any{
+		"format":    "eddystone_tlm",
+		"batt_mv":   be16(svc[4:6]),
+		"temp_c":    float64(int16(be16(svc[6:8]))) / 256,
+		"adv_count": be32(svc[8:12]),
+		"uptime_ds": be32(svc[12:16]), // 0.1 s units
+	}
+}
+
+func scanJSON(beacons []ScanBeacon) []map[string]any {
+	out := make([]map[string]any, 0, len(beacons))
+	for _, b := range beacons {
+		e := map[string]any{"mac": b.MAC, "rssi": b.RSSI, "service_data": b.ServiceData}
+		if b.Sensor != nil {
+			e["sensor"] = b.Sensor
+		}
+		out = append(out, e)
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SensorReading is one attached BLE sensor forwarded under tag 0x22.
+type SensorReading struct {
+	Slot   int               // sensor entry tag (gateway-assigned slot)
+	MAC    string            // uppercase hex, "" when the entry has no sub-tag 0x00
+	Values map[string]any    // decoded sub-tags: temp_c, door, button_presses
+	Raw    map[string]string // unknown sub-tags: "0x07" -> value hex
+}
+
+// sensorContainerTag holds one TLV per attached sensor; each sensor's
+// value is itself a run of sub-TLVs:
+//
+//	0x00 MAC (6) | 0x01 temperature (int16, 0.01 °C) | 0x02 door (uint8) | 0x03 button presses (uint16)
+const sensorContainerTag = 0x22
+
+// doorStateNames maps sub-tag 0x02.
+var doorStateNames = []string{"closed", "open"}
+
+// parseSensors decodes every top-level tag 0x22 container in body.
+func parseSensors(body []byte) ([]SensorReading, error) {
+	var out []SensorReading
+	var err error
+	walkTLV(body, func(tag byte, v []byte) bool {
+		if tag != sensorContainerTag {
+			return true
+		}
+		var list []SensorReading
+		if list, err = parseSensorContainer(v); err != nil {
+			return false
+		}
+		out = append(out, list...)
+		return true
+	})
+	return out, err
+}
+
+func parseSensorContainer(v []byte) ([]SensorReading, error) {
+	var out []SensorReading
+	for i := 0; i < len(v); {
+		if i+3 > len(v) {
+			return nil, errors.New("sensor entry len OOB")
+		}
+		slot, ln := int(v[i]), be16(v[i+1:])
+		i += 3
+		if i+ln > len(v) {
+			return nil, errors.New("sensor entry OOB")
+		}
+		r, err := parseSensorEntry(slot, v[i:i+ln])
+		if err != nil {
+			return nil, fmt.Errorf("sensor slot %d: %w", slot, err)
+		}
+		out = append(out, r)
+		i += ln
+	}
+	return out, nil
+}
+
+func parseSensorEntry(slot int, v []byte) (SensorReading, error) {
+	r := SensorReading{Slot: slot, Values: map[string]any{}}
+	for i := 0; i < len(v); {
+		if i+3 > len(v) {
+			return r, errors.New("sub-tlv len OOB")
+		}
+		tag, ln := v[i], be16(v[i+1:])
+		i += 3
+		if i+ln > len(v) {
+			return r, errors.New("sub-tlv OOB")
+		}
+		val := v[i : i+ln]
+		switch {
+		case tag == 0x00 && ln == 6:
+			r.MAC = strings.ToUpper(hex.EncodeToString(val))
+		case tag == 0x01 && ln >= 2:
+			r.Values["temp_c"] = float64(int16(be16(val))) / 100
+		case tag == 0x02 && ln >= 1:
+			r.Values["door"] = enumName(doorStateNames, val[0])
+		case tag == 0x03 && ln >= 2:
+			r.Values["button_presses"] = be16(val)
+		default:
+			if r.Raw == nil {
+				r.Raw = map[string]string{}
+			}
+			r.Raw[fmt.Sprintf("0x%02X", tag)] = strings.ToUpper(hex.EncodeToString(val))
+		}
+		i += ln
+	}
+	return r, nil
+}
+
+func sensorsJSON(readings []SensorReading) []map[string]any {
+	out := make([]map[string]any, 0, len(readings))
+	for _, r := range readings {
+		e := map[string]any{"slot": r.Slot}
+		if r.MAC != "" {
+			e["mac"] = r.MAC
+		}
+		for k, v := range r.Values {
+			e[k] = v
+		}
+		if r.Raw != nil {
+			e["raw"] = r.Raw
+		}
+		out = append(out, e)
+	}
+	return out
+}
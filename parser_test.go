@@ -0,0 +1,773 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tlv builds one top-level TLV in hex: tag (1) | value length (uint16) | value.
+func tlv(tag byte, valueHex string) string {
+	return fmt.Sprintf("%02X%04X%s", tag, len(valueHex)/2, valueHex)
+}
+
+const tsTLV = "00000465F0B6C0" // tag 0x00, 0x65F0B6C0
+
+// withDecodeOpts applies set to decodeOpts for the test.
+func withDecodeOpts(t *testing.T, set func(o *DecodeOptions)) {
+	t.Helper()
+	prev := decodeOpts
+	set(&decodeOpts)
+	t.Cleanup(func() { decodeOpts = prev })
+}
+
+func TestDecodeMKGW4Auto(t *testing.T) {
+	statusBody := tsTLV + tlv(0x01, hex.EncodeToString([]byte("LTE-M"))) + tlv(0x02, "1F") + tlv(0x03, "0F3C")
+	cases := []struct {
+		name  string
+		flag  string
+		hex   string
+		check func(t *testing.T, a *Auto)
+	}{
+		{"status", "3004", statusBody, func(t *testing.T, a *Auto) {
+			st := a.Status
+			if st.NetworkType != "LTE-M" || st.CSQ != 31 || st.BattmV != 3900 {
+				t.Errorf("status %+v", st)
+			}
+			if !a.HasFrameTs || a.Timestamp != 0x65F0B6C0 {
+				t.Errorf("ts %d (frame %v)", a.Timestamp, a.HasFrameTs)
+			}
+		}},
+		{"lower-case flag and hex", "30b1", strings.ToLower(goldenFixHex), func(t *testing.T, a *Auto) {
+			if err := checkGoldenFix(a); err != nil {
+				t.Error(err)
+			}
+		}},
+		{"axes are signed", "3004", tsTLV + tlv(0x04, "0AF6C0"), func(t *testing.T, a *Auto) {
+			st := a.Status
+			if st.AxisXmg != 10 || st.AxisYmg != -10 || st.AxisZmg != -64 {
+				t.Errorf("axes %d/%d/%d", st.AxisXmg, st.AxisYmg, st.AxisZmg)
+			}
+			if st.TiltDegrees <= 90 {
+				t.Errorf("upside-down tilt %g", st.TiltDegrees)
+			}
+		}},
+		{"negative temperature", "3004", tsTLV + tlv(0x10, "FF9C"), func(t *testing.T, a *Auto) {
+			if a.Status.TempC != -10 || !a.Status.Has("temp_c") {
+				t.Errorf("temp %g", a.Status.TempC)
+			}
+		}},
+		{"cell id and tac", "3089", goldenFixHex + tlv(0x04, "0001E240"+"1234"), func(t *testing.T, a *Auto) {
+			f := a.Fix
+			if f.CI != 123456 || f.TacLac != 0x1234 || !f.Has("tac_lac") || !f.Has("ci") {
+				t.Errorf("cell %d/%d present %v", f.CI, f.TacLac, f.Present)
+			}
+		}},
+		{"compact coordinates", "3089", tsTLV + tlv(0x03, "147B40"+"4FE1A0"), func(t *testing.T, a *Auto) {
+			f := a.Fix
+			if math.Abs(f.Longitude-13.42272) > 1e-9 || math.Abs(f.Latitude-52.35104) > 1e-9 {
+				t.Errorf("lon/lat %g/%g", f.Longitude, f.Latitude)
+			}
+		}},
+		{"compact negative coordinates", "3089", tsTLV + tlv(0x03, "BE1118"+"DD0EB0"), func(t *testing.T, a *Auto) {
+			f := a.Fix
+			if math.Abs(f.Longitude+43.21) > 1e-9 || math.Abs(f.Latitude+22.9) > 1e-9 || !f.Has("lat") {
+				t.Errorf("lon/lat %g/%g present %v", f.Longitude, f.Latitude, f.Present)
+			}
+		}},
+		{"compact coordinates int24 min", "3089", tsTLV + tlv(0x03, "800000"+"147B40"), func(t *testing.T, a *Auto) {
+			if math.Abs(a.Fix.Longitude+83.88608) > 1e-9 {
+				t.Errorf("lon %g", a.Fix.Longitude)
+			}
+		}},
+		{"repeated neighbors", "3089", goldenFixHex + tlv(0x0A, "00000001C4") + tlv(0x0A, "00000002B0"), func(t *testing.T, a *Auto) {
+			want := []NeighborCell{{CI: 1, RSSI: -60}, {CI: 2, RSSI: -80}}
+			if !slices.Equal(a.Fix.NeighborCells, want) {
+				t.Errorf("neighbors %+v", a.Fix.NeighborCells)
+			}
+			if len(a.Warnings) > 0 {
+				t.Errorf("repeatable tag warned: %v", a.Warnings)
+			}
+		}},
+		{"fix accuracy", "3089", goldenFixHex + tlv(0x1B, "0190"), func(t *testing.T, a *Auto) {
+			if a.Fix.AccuracyM != 400 || !a.Fix.Has("accuracy_m") {
+				t.Errorf("accuracy %d present %v", a.Fix.AccuracyM, a.Fix.Present)
+			}
+		}},
+		{"antenna status", "3089", goldenFixHex + tlv(0x21, "01"), func(t *testing.T, a *Auto) {
+			if a.Fix.AntennaStatus != "open" {
+				t.Errorf("antenna %q", a.Fix.AntennaStatus)
+			}
+		}},
+		{"compact fix struct", compactFixFlag, "00" + "00" + "07FD70D0" + "1F4DEA80" + "0007", func(t *testing.T, a *Auto) {
+			if err := checkGoldenFix(a); err != nil {
+				t.Error(err)
+			}
+			if a.Fix.DownlinkID != 7 || a.HasFrameTs {
+				t.Errorf("downlink %d, frame ts %v", a.Fix.DownlinkID, a.HasFrameTs)
+			}
+		}},
+		{"embedded flag", "", "FF00023004" + statusBody, func(t *testing.T, a *Auto) {
+			if a.Flag != "3004" || a.Status == nil || a.Status.CSQ != 31 {
+				t.Errorf("flag %q status %+v", a.Flag, a.Status)
+			}
+		}},
+		{"frame header", "3004", fmt.Sprintf("EF3004%04X", len(statusBody)/2) + statusBody, func(t *testing.T, a *Auto) {
+			if a.Status == nil || a.Status.CSQ != 31 || len(a.Warnings) > 0 {
+				t.Errorf("status %+v warnings %v", a.Status, a.Warnings)
+			}
+		}},
+		{"frame header length mismatch", "3004", "EF30040001" + statusBody, func(t *testing.T, a *Auto) {
+			if len(a.Warnings) != 1 || !strings.Contains(a.Warnings[0], "frame length mismatch") {
+				t.Errorf("warnings %v", a.Warnings)
+			}
+		}},
+		{"sequence number", "3004", statusBody + tlv(0x12, "0102"), func(t *testing.T, a *Auto) {
+			if !a.HasSeq || a.SeqNo != 258 {
+				t.Errorf("seq %d (has %v)", a.SeqNo, a.HasSeq)
+			}
+		}},
+		{"duplicate tag", "3004", statusBody + tlv(0x02, "10"), func(t *testing.T, a *Auto) {
+			if a.Status.CSQ != 16 || !slices.Contains(a.Warnings, "duplicate tag 0x02") {
+				t.Errorf("csq %d warnings %v", a.Status.CSQ, a.Warnings)
+			}
+		}},
+		{"sensor container", "3004", statusBody + tlv(sensorContainerTag, tlv(0x01, tlv(0x00, "AABBCCDDEEFF")+tlv(0x01, "0929")+tlv(0x02, "01"))), func(t *testing.T, a *Auto) {
+			if len(a.Sensors) != 1 {
+				t.Fatalf("sensors %+v", a.Sensors)
+			}
+			s := a.Sensors[0]
+			if s.Slot != 1 || s.MAC != "AABBCCDDEEFF" || s.Values["temp_c"] != 23.45 || s.Values["door"] != "open" {
+				t.Errorf("sensor %+v", s)
+			}
+		}},
+		{"two sensors", "3004", statusBody + tlv(sensorContainerTag,
+			tlv(0x01, tlv(0x00, "AABBCCDDEEFF")+tlv(0x01, "FF38"))+
+				tlv(0x02, tlv(0x00, "112233445566")+tlv(0x03, "0007")+tlv(0x02, "05")+tlv(0x07, "BEEF"))), func(t *testing.T, a *Auto) {
+			if len(a.Sensors) != 2 {
+				t.Fatalf("sensors %+v", a.Sensors)
+			}
+			s1, s2 := a.Sensors[0], a.Sensors[1]
+			if s1.Slot != 1 || s1.MAC != "AABBCCDDEEFF" || s1.Values["temp_c"] != -2.0 || s1.Raw != nil {
+				t.Errorf("sensor 1 %+v", s1)
+			}
+			if s2.Slot != 2 || s2.MAC != "112233445566" || s2.Values["button_presses"] != 7 || s2.Values["door"] != "unknown(5)" {
+				t.Errorf("sensor 2 %+v", s2)
+			}
+			if s2.Raw["0x07"] != "BEEF" {
+				t.Errorf("sensor 2 raw %v", s2.Raw)
+			}
+		}},
+		{"truncated sensor container", "3004", statusBody + tlv(sensorContainerTag, "0100"), func(t *testing.T, a *Auto) {
+			if a.Sensors != nil || !slices.Contains(a.Warnings, "sensors: sensor entry len OOB") {
+				t.Errorf("sensors %+v warnings %v", a.Sensors, a.Warnings)
+			}
+		}},
+		{"crash dump", "30E0", hex.EncodeToString([]byte("assert: x\n\x00\x00")), func(t *testing.T, a *Auto) {
+			if a.CrashDump != "assert: x" || a.HasFrameTs {
+				t.Errorf("dump %q", a.CrashDump)
+			}
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok, err := decodeMKGW4Auto(tc.flag, tc.hex, false)
+			if err != nil || !ok {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			tc.check(t, a)
+		})
+	}
+}
+
+func TestIdentifiersASCIIOrBCD(t *testing.T) {
+	const iccid, imei = "89441000300012345678", "356938035643809"
+	cases := []struct {
+		name, tag, valueHex, want string
+		bcd                       bool
+	}{
+		{"ascii iccid", "iccid", hex.EncodeToString([]byte(iccid)), iccid, true},
+		{"bcd iccid", "iccid", "98440100030021436587", iccid, true},
+		{"ascii imei", "imei", hex.EncodeToString([]byte(imei)), imei, true},
+		{"bcd imei with F padding", "imei", "539683306534" + "08F9", imei, true},
+		{"bcd off keeps the bytes", "iccid", "9844", "\x98D", false},
+		{"not bcd either", "iccid", "9A44", "\x9aD", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withDecodeOpts(t, func(o *DecodeOptions) { o.BCDIdentifiers, o.SanitizeASCII = tc.bcd, false })
+			tag := byte(0x07)
+			if tc.tag == "imei" {
+				tag = 0x06
+			}
+			a, _, err := decodeMKGW4Auto("3004", tsTLV+tlv(tag, tc.valueHex), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := a.Status.ICCID
+			if tc.tag == "imei" {
+				got = a.Status.IMEI
+			}
+			if got != tc.want {
+				t.Errorf("%s = %q, want %q", tc.tag, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSwappedBCD(t *testing.T) {
+	cases := []struct {
+		in     []byte
+		want   string
+		wantOK bool
+	}{
+		{[]byte{0x21, 0x43}, "1234", true},
+		{[]byte{0x21, 0xF3}, "123", true},
+		{[]byte{0xFF}, "", false},
+		{[]byte{0x1A}, "", false},
+	}
+	for _, tc := range cases {
+		got, ok := swappedBCD(tc.in)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("swappedBCD(% X) = %q, %v", tc.in, got, ok)
+		}
+	}
+}
+
+func TestTLVEntryCap(t *testing.T) {
+	withDecodeOpts(t, func(o *DecodeOptions) { o.MaxTLVEntries = 4 })
+	cases := []struct {
+		name, flag string
+		entries    int
+		wantErr    bool
+	}{
+		{"status at the cap", "3004", 4, false},
+		{"status past the cap", "3004", 5, true},
+		{"fix at the cap", "3089", 4, false},
+		{"fix past the cap", "3089", 5, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := tsTLV
+			for range tc.entries - 1 {
+				if tc.flag == "3004" {
+					body += tlv(0x02, "1F")
+				} else {
+					body += tlv(0x0A, "00000001C4")
+				}
+			}
+			_, _, err := decodeMKGW4Auto(tc.flag, body, false)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("err = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "more than 4 entries") {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckFixBounds(t *testing.T) {
+	// A geofence around Europe.
+	withDecodeOpts(t, func(o *DecodeOptions) { o.MinLat, o.MaxLat, o.MinLon, o.MaxLon = 35, 72, -25, 45 })
+	cases := []struct {
+		name         string
+		lat, lon     float64
+		wantRejected bool
+	}{
+		{"valid", 52.35, 13.42, false},
+		{"null island", 0, 0, true},
+		{"zero lon only is fine", 51.48, 0, false},
+		{"outside the geofence", -33.9, 18.4, true},
+		{"off the globe", 90.5, 180, true},
+		{"on the edge", 72, 45, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &Auto{Fix: &AutoFix{Latitude: tc.lat, Longitude: tc.lon, FixResult: "success", Present: []string{"lon", "lat", "ci"}}}
+			a.checkFixBounds()
+			f := a.Fix
+			if tc.wantRejected {
+				if f.Has("lat") || f.Has("lon") || f.Latitude != 0 || f.Longitude != 0 {
+					t.Errorf("position kept: %+v", f)
+				}
+				if f.FixResult != "success (position rejected)" || len(a.Warnings) != 1 || !f.Has("ci") {
+					t.Errorf("result %q warnings %v present %v", f.FixResult, a.Warnings, f.Present)
+				}
+				return
+			}
+			if !f.Has("lat") || f.Latitude != tc.lat || f.FixResult != "success" || len(a.Warnings) != 0 {
+				t.Errorf("valid fix changed: %+v warnings %v", f, a.Warnings)
+			}
+		})
+	}
+}
+
+// TestParseStatusTLVTags covers one status tag per row: the value decoded,
+// and that a short value leaves the field absent.
+func TestParseStatusTLVTags(t *testing.T) {
+	cases := []struct {
+		name, body, key string
+		got             func(st *AutoStatus) any
+		want            any
+	}{
+		{"boot count", tlv(0x13, "0000012C"), "boot_count", func(st *AutoStatus) any { return st.BootCount }, int64(300)},
+		{"boot count past int32", tlv(0x13, "FFFFFFFF"), "boot_count", func(st *AutoStatus) any { return st.BootCount }, int64(4294967295)},
+		{"boot count too short", tlv(0x13, "0001"), "", nil, nil},
+		{"uptime", tlv(0x14, "00015180"), "uptime_sec", func(st *AutoStatus) any { return st.UptimeSec }, int64(86400)},
+		{"uptime too short", tlv(0x14, "000151"), "", nil, nil},
+		{"battery temperature", tlv(0x15, "19"), "batt_temp_c", func(st *AutoStatus) any { return st.BattTempC }, 25},
+		{"negative battery temperature", tlv(0x15, "E2"), "batt_temp_c", func(st *AutoStatus) any { return st.BattTempC }, -30},
+		{"battery temperature min", tlv(0x15, "80"), "batt_temp_c", func(st *AutoStatus) any { return st.BattTempC }, -128},
+		{"battery temperature empty", tlv(0x15, ""), "", nil, nil},
+		{"rsrp", tlv(0x18, "FF9CF6"), "rsrp", func(st *AutoStatus) any { return st.RSRP }, -100},
+		{"rsrq", tlv(0x18, "FF9CF6"), "rsrq", func(st *AutoStatus) any { return st.RSRQ }, -10},
+		{"rsrp without rsrq", tlv(0x18, "FF9C"), "", nil, nil},
+		{"plmn 2-digit mnc", tlv(0x19, "62F210"), "plmn", func(st *AutoStatus) any { return st.PLMN }, "262-01"},
+		{"plmn 3-digit mnc", tlv(0x19, "130062"), "plmn", func(st *AutoStatus) any { return st.PLMN }, "310-260"},
+		{"plmn operator name", tlv(0x19, hex.EncodeToString([]byte("Vodafone"))), "plmn", func(st *AutoStatus) any { return st.PLMN }, "Vodafone"},
+		{"config version", tlv(0x1A, "0102"), "config_version", func(st *AutoStatus) any { return st.ConfigVersion }, 258},
+		{"config version too short", tlv(0x1A, "01"), "", nil, nil},
+		{"wake reason", tlv(0x1C, "01"), "wake_reason", func(st *AutoStatus) any { return st.WakeReason }, "accelerometer"},
+		{"wake reason unknown code", tlv(0x1C, "09"), "wake_reason", func(st *AutoStatus) any { return st.WakeReason }, "unknown(9)"},
+		{"board temperature", tlv(0x1D, "01C2"), "board_temp_c", func(st *AutoStatus) any { return st.BoardTempC }, 45.0},
+		{"negative board temperature", tlv(0x1D, "FF38"), "board_temp_c", func(st *AutoStatus) any { return st.BoardTempC }, -20.0},
+		{"link quality", tlv(0x1E, "5A"), "link_quality", func(st *AutoStatus) any { return st.LinkQuality }, 90},
+		{"link quality empty", tlv(0x1E, ""), "", nil, nil},
+		{"report interval", tlv(0x1F, "0E10"), "report_interval_sec", func(st *AutoStatus) any { return st.ReportIntervalSec }, 3600},
+		{"report interval too short", tlv(0x1F, "0E"), "", nil, nil},
+		{"tamper closed", tlv(0x20, "0065F0B6C0"), "tamper_state", func(st *AutoStatus) any { return st.TamperState }, "closed"},
+		{"tamper open", tlv(0x20, "0165F0B6C0"), "tamper_state", func(st *AutoStatus) any { return st.TamperState }, "open"},
+		{"tamper event time", tlv(0x20, "0165F0B6C0"), "tamper_ts", func(st *AutoStatus) any { return st.TamperTs }, int64(1710274240)},
+		{"tamper unknown state", tlv(0x20, "0765F0B6C0"), "tamper_state", func(st *AutoStatus) any { return st.TamperState }, "unknown(7)"},
+		{"tamper without timestamp", tlv(0x20, "01"), "", nil, nil},
+		{"pending downlinks", tlv(0x23, "03"), "pending_downlinks", func(st *AutoStatus) any { return st.PendingDownlinks }, 3},
+		{"no pending downlinks", tlv(0x23, "00"), "pending_downlinks", func(st *AutoStatus) any { return st.PendingDownlinks }, 0},
+		{"pending downlinks empty", tlv(0x23, ""), "", nil, nil},
+		{"motion events", tlv(0x24, "012C"), "motion_events", func(st *AutoStatus) any { return st.MotionEvents }, 300},
+		{"motion events too short", tlv(0x24, "01"), "", nil, nil},
+		{"moving seconds", tlv(0x25, "FFFF"), "moving_sec", func(st *AutoStatus) any { return st.MovingSec }, 65535},
+		{"moving seconds too short", tlv(0x25, "0E"), "", nil, nil},
+		{"power source only", tlv(0x26, "01"), "power_source", func(st *AutoStatus) any { return st.PowerSource }, "external"},
+		{"solar power source", tlv(0x26, "0201"), "power_source", func(st *AutoStatus) any { return st.PowerSource }, "solar"},
+		{"solar charging", tlv(0x26, "0201"), "charging_state", func(st *AutoStatus) any { return st.ChargingState }, "charging"},
+		{"unknown power source", tlv(0x26, "0902"), "power_source", func(st *AutoStatus) any { return st.PowerSource }, "unknown(9)"},
+		{"power source empty", tlv(0x26, ""), "", nil, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, _, err := parseStatusTLV(mustHex(t, tsTLV+tlv(0x02, "1F")+tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.key == "" {
+				if len(st.Present) != 1 { // csq only
+					t.Errorf("present %v", st.Present)
+				}
+				return
+			}
+			if !st.Has(tc.key) {
+				t.Errorf("%s not present: %v", tc.key, st.Present)
+			}
+			if got := tc.got(st); got != tc.want {
+				t.Errorf("%s = %v (%T), want %v (%T)", tc.key, got, got, tc.want, tc.want)
+			}
+		})
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestTLVLengthConventions(t *testing.T) {
+	tlvIncl := func(tag byte, valueHex string) string {
+		return fmt.Sprintf("%02X%04X%s", tag, len(valueHex)/2+3, valueHex)
+	}
+	net := hex.EncodeToString([]byte("LTE-M"))
+	valueOnly := tsTLV + tlv(0x01, net) + tlv(0x02, "1F") + tlv(0x03, "0F3C")
+	inclusive := tlvIncl(0x00, "65F0B6C0") + tlvIncl(0x01, net) + tlvIncl(0x02, "1F") + tlvIncl(0x03, "0F3C")
+
+	cases := []struct {
+		name        string
+		mode        TLVLenMode
+		body        string
+		wantProfile string
+		wantInclude bool
+	}{
+		{"value-only frame, value mode", TLVLenValue, valueOnly, "", false},
+		{"inclusive frame, inclusive mode", TLVLenInclusive, inclusive, "len+hdr", true},
+		{"value-only frame, auto", TLVLenAuto, valueOnly, "", false},
+		{"inclusive frame, auto", TLVLenAuto, inclusive, "len+hdr", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withDecodeOpts(t, func(o *DecodeOptions) { o.TLVLength = tc.mode })
+			if got := inclusiveTLVLengths(mustHex(t, tc.body)); got != tc.wantInclude {
+				t.Errorf("inclusiveTLVLengths = %v", got)
+			}
+			a, ok, err := decodeMKGW4Auto("3004", tc.body, false)
+			if !ok || err != nil {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			st := a.Status
+			if st.NetworkType != "LTE-M" || st.CSQ != 31 || st.BattmV != 3900 || a.Timestamp != 0x65F0B6C0 {
+				t.Errorf("status %+v ts %d", st, a.Timestamp)
+			}
+			if a.Profile != tc.wantProfile {
+				t.Errorf("profile %q, want %q", a.Profile, tc.wantProfile)
+			}
+		})
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	older := "000004" + "65F0B600" + tlv(0x02, "0A")
+	newer := tsTLV + tlv(0x02, "1F") + tlv(0x03, "0F3C")
+	marker := tlv(statusSnapshotMarker, "")
+	cases := []struct {
+		name    string
+		body    string
+		wantCSQ []int // history order, as in the frame
+	}{
+		{"oldest first", older + marker + newer, []int{10, 31}},
+		{"newest first", newer + marker + older, []int{31, 10}},
+		{"leading marker", marker + older + marker + newer, []int{10, 31}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok, err := decodeMKGW4Auto("3004", tc.body, false)
+			if !ok || err != nil {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			var csq []int
+			for _, snap := range a.StatusHistory {
+				csq = append(csq, snap.Status.CSQ)
+			}
+			if !slices.Equal(csq, tc.wantCSQ) {
+				t.Errorf("history csq %v, want %v", csq, tc.wantCSQ)
+			}
+			if a.Status.CSQ != 31 || a.Timestamp != 0x65F0B6C0 {
+				t.Errorf("latest csq %d ts %d", a.Status.CSQ, a.Timestamp)
+			}
+
+			d, res, ok := decodeEnvelope(Envelope{GWHW: "MKGW4", GWMAC: "CCE01BA20624", Flag: "self/3004", PayloadHex: tc.body}, time.Now())
+			if !ok {
+				t.Fatalf("decodeEnvelope: %+v", res)
+			}
+			hist, _ := d.parsed["status_history"].([]map[string]any)
+			if len(hist) != 2 || hist[0]["ts"] == hist[1]["ts"] {
+				t.Errorf("status_history %v", d.parsed["status_history"])
+			}
+			if d.st == nil || d.st.CSQ != 31 {
+				t.Errorf("denorm status %+v", d.st)
+			}
+		})
+	}
+}
+
+// TestClassifyCellArea files the tag 0x04 area code by the 30C0 status
+// network type; fix-only frames keep the TAC reading.
+func TestClassifyCellArea(t *testing.T) {
+	cell := tlv(0x04, "0001E240"+"1234")
+	for _, tc := range []struct {
+		name, flag, body string
+		lac              bool
+	}{
+		{"GSM is LAC", "30C0", tsTLV + tlv(0x01, hex.EncodeToString([]byte("GSM"))) + tlv(0x30, cell), true},
+		{"WCDMA is LAC, case-insensitive", "30C0", tsTLV + tlv(0x01, hex.EncodeToString([]byte(" wcdma "))) + tlv(0x30, cell), true},
+		{"LTE is TAC", "30C0", tsTLV + tlv(0x01, hex.EncodeToString([]byte("LTE"))) + tlv(0x30, cell), false},
+		{"NB-IoT is TAC", "30C0", tsTLV + tlv(0x01, hex.EncodeToString([]byte("NB-IoT"))) + tlv(0x30, cell), false},
+		{"fix-only frame is TAC", "3089", tsTLV + cell, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok, err := decodeMKGW4Auto(tc.flag, tc.body, false)
+			if !ok || err != nil {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			f := a.Fix
+			if f == nil || f.TacLac != 0x1234 {
+				t.Fatalf("fix %+v", f)
+			}
+			if tc.lac {
+				if !f.Has("lac") || f.Has("tac") || f.LAC != 0x1234 {
+					t.Errorf("lac %d/tac %d present %v", f.LAC, f.TAC, f.Present)
+				}
+			} else if !f.Has("tac") || f.Has("lac") || f.TAC != 0x1234 {
+				t.Errorf("lac %d/tac %d present %v", f.LAC, f.TAC, f.Present)
+			}
+		})
+	}
+}
+
+func TestTampered(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want bool
+	}{
+		{"", false},
+		{tlv(0x20, "0065F0B6C0"), false},
+		{tlv(0x20, "0165F0B6C0"), true},
+		{tlv(0x20, "0765F0B6C0"), true},
+	} {
+		st, _, err := parseStatusTLV(mustHex(t, tsTLV+tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := st.Tampered(); got != tc.want {
+			t.Errorf("%q: Tampered() = %v, want %v", tc.body, got, tc.want)
+		}
+	}
+	if (*AutoStatus)(nil).Tampered() {
+		t.Error("nil status reported tampered")
+	}
+}
+
+func TestSanitizeASCIITags(t *testing.T) {
+	cases := []struct {
+		name     string
+		sanitize bool
+		tag      byte
+		raw      string
+		want     string
+		warning  string
+	}{
+		{"embedded NULs", true, 0x01, "LTE-M\x00\x00", "LTE-M", "network_type: stripped 2 non-printable bytes"},
+		{"control bytes", true, 0x01, "NB\x07-IoT\x1b", "NB-IoT", "network_type: stripped 2 non-printable bytes"},
+		{"high bytes", true, 0x19, "Voda\xfffone", "Vodafone", "plmn: stripped 1 non-printable bytes"},
+		{"clean value", true, 0x01, "LTE-M", "LTE-M", ""},
+		{"sanitizer off", false, 0x01, "LTE-M\x00", "LTE-M\x00", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withDecodeOpts(t, func(o *DecodeOptions) { o.SanitizeASCII = tc.sanitize })
+			a, ok, err := decodeMKGW4Auto("3004", tsTLV+tlv(tc.tag, hex.EncodeToString([]byte(tc.raw))), false)
+			if !ok || err != nil {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			got := a.Status.NetworkType
+			if tc.tag == 0x19 {
+				got = a.Status.PLMN
+			}
+			if got != tc.want {
+				t.Errorf("value %q, want %q", got, tc.want)
+			}
+			if tc.warning == "" && len(a.Warnings) > 0 || tc.warning != "" && !slices.Contains(a.Warnings, tc.warning) {
+				t.Errorf("warnings %v, want %q", a.Warnings, tc.warning)
+			}
+		})
+	}
+}
+
+func TestDecodeMKGW4AutoRejects(t *testing.T) {
+	cases := []struct {
+		name, flag, hex string
+		wantOK          bool
+	}{
+		{"bad hex", "3004", "ZZ", false},
+		{"truncated header", "3004", "0200", true},
+		{"value past the end", "3004", "020005" + "1F", true},
+		{"compact fix wrong size", compactFixFlag, "0000", true},
+		{"unknown flag", "9999", tsTLV, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok, err := decodeMKGW4Auto(tc.flag, tc.hex, false)
+			if ok != tc.wantOK || a != nil {
+				t.Errorf("ok=%v a=%+v, want ok=%v and no decode", ok, a, tc.wantOK)
+			}
+			if (err == nil) == (tc.name != "unknown flag") {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeMKGW4Envelope(t *testing.T) {
+	statusBody := tsTLV + tlv(0x02, "1F")
+	prev := defaultFlags
+	defaultFlags = map[string]string{"MKGW4": "3004"}
+	t.Cleanup(func() { defaultFlags = prev })
+
+	cases := []struct {
+		name        string
+		env         Envelope
+		wantFlag    string
+		wantAssumed bool
+		wantAuto    bool
+	}{
+		{"envelope flag", Envelope{GWHW: "MKGW4", Flag: "self/3004", PayloadHex: statusBody}, "self/3004", false, true},
+		{"bare flag", Envelope{GWHW: "MKGW4", Flag: "3004", PayloadHex: statusBody}, "self/3004", false, true},
+		{"header names the flag", Envelope{GWHW: "MKGW4", PayloadHex: fmt.Sprintf("EF3089%04X", len(goldenFixHex)/2) + goldenFixHex}, "self/3089", false, true},
+		{"model default", Envelope{GWHW: "MKGW4", PayloadHex: statusBody}, "self/3004", true, true},
+		{"no flag anywhere", Envelope{GWHW: "MKGW4X", PayloadHex: statusBody}, "json", false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := decodeMKGW4Envelope(tc.env)
+			if d.Flag != tc.wantFlag || d.FlagAssumed != tc.wantAssumed || (d.Auto != nil) != tc.wantAuto {
+				t.Errorf("flag %q assumed %v auto %v", d.Flag, d.FlagAssumed, d.Auto != nil)
+			}
+		})
+	}
+}
+
+func TestDecodeMKGWMini01(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		wantOK  bool
+		wantErr bool
+		check   func(t *testing.T, a *Auto)
+	}{
+		{"status and ms timestamp", `{"msg_id":3004,"data":{"timestamp":1718000000123,"net_type":"WIFI","csq":24,"battery_voltage":3950}}`, true, false, func(t *testing.T, a *Auto) {
+			if a.Timestamp != 1718000000 || a.Status.CSQ != 24 || a.Status.BattmV != 3950 || a.Flag != "3004" {
+				t.Errorf("ts %d status %+v flag %q", a.Timestamp, a.Status, a.Flag)
+			}
+		}},
+		{"ci and tac past 2^53", `{"data":{"ci":"9007199254740993","tac":"4660"}}`, true, false, func(t *testing.T, a *Auto) {
+			if a.Fix.CI != 9007199254740993 || a.Fix.TacLac != 4660 || !a.Fix.Has("tac_lac") {
+				t.Errorf("fix %+v", a.Fix)
+			}
+		}},
+		{"ci without tac", `{"data":{"ci":268435455}}`, true, false, func(t *testing.T, a *Auto) {
+			if !a.Fix.Has("ci") || a.Fix.Has("tac_lac") {
+				t.Errorf("present %v", a.Fix.Present)
+			}
+		}},
+		{"float ci", `{"data":{"ci":2.68e8}}`, true, false, func(t *testing.T, a *Auto) {
+			if a.Fix.CI != 268000000 {
+				t.Errorf("ci %d", a.Fix.CI)
+			}
+		}},
+		{"fractional ci warns", `{"data":{"ci":1.5,"csq":3}}`, true, false, func(t *testing.T, a *Auto) {
+			if a.Fix != nil || len(a.Warnings) != 1 {
+				t.Errorf("fix %+v warnings %v", a.Fix, a.Warnings)
+			}
+		}},
+		{"string msg_id", `{"msg_id":"3004","data":{"csq":5}}`, true, false, func(t *testing.T, a *Auto) {
+			if a.Flag != "3004" || len(a.Warnings) != 0 {
+				t.Errorf("flag %q warnings %v", a.Flag, a.Warnings)
+			}
+		}},
+		{"float msg_id warns", `{"msg_id":30.5,"data":{"csq":5}}`, true, false, func(t *testing.T, a *Auto) {
+			if a.Flag != "0" || len(a.Warnings) != 1 {
+				t.Errorf("flag %q warnings %v", a.Flag, a.Warnings)
+			}
+		}},
+		{"nothing known", `{"msg_id":1,"data":{"other":1}}`, false, false, nil},
+		{"bad json", `{`, false, true, nil},
+		{"bad timestamp", `{"data":{"timestamp":"x","csq":1}}`, false, true, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok, err := DecodeMKGWMini01([]byte(tc.body))
+			if ok != tc.wantOK || (err != nil) != tc.wantErr {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			if tc.check != nil {
+				tc.check(t, a)
+			}
+		})
+	}
+}
+
+func TestDecodeMKGWMini01FloatNumbers(t *testing.T) {
+	prev := jsonFloatNumbers
+	jsonFloatNumbers = true
+	t.Cleanup(func() { jsonFloatNumbers = prev })
+
+	a, ok, err := DecodeMKGWMini01([]byte(`{"msg_id":"3004","data":{"ci":"9007199254740993"}}`))
+	if !ok || err != nil {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+	if a.Fix.CI != 9007199254740992 || a.Flag != "3004" { // rounded, as before exact decoding
+		t.Errorf("ci %d flag %q", a.Fix.CI, a.Flag)
+	}
+}
+
+func TestJSONInt(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"42", 42, false},
+		{"-7", -7, false},
+		{"9223372036854775807", math.MaxInt64, false},
+		{"2.68e8", 268000000, false},
+		{"1.5", 0, true},
+		{"9.223372036854775807e18", 0, true}, // rounds to 2^63
+		{"1e300", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tc := range cases {
+		got, err := jsonInt(json.Number(tc.in))
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("jsonInt(%s) = %d, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestGeohash(t *testing.T) {
+	cases := []struct {
+		lat, lon  float64
+		precision int
+		want      string
+	}{
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{-25.382708, -49.265506, 8, "6gkzwgjz"},
+		{0, 0, 1, "s"},
+	}
+	for _, tc := range cases {
+		if got := geohash(tc.lat, tc.lon, tc.precision); got != tc.want {
+			t.Errorf("geohash(%g, %g, %d) = %q, want %q", tc.lat, tc.lon, tc.precision, got, tc.want)
+		}
+	}
+}
+
+func TestDeriveHeaderFlag(t *testing.T) {
+	cases := map[string]string{
+		"EF30040005":   "3004",
+		"ef:30:89:00":  "3089",
+		"EE30040005":   "", // not a configured magic
+		"EF30":         "",
+		"0200011F0300": "",
+	}
+	for in, want := range cases {
+		if got := deriveHeaderFlag(in); got != want {
+			t.Errorf("deriveHeaderFlag(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"CC:E0:1B:A2:06:24", "CCE01BA20624", false},
+		{"cc-e0-1b-a2-06-24", "CCE01BA20624", false},
+		{" cce0.1ba2.0624 ", "CCE01BA20624", false},
+		{"CCE01BA206", "", true},
+		{"CCE01BA2062Z", "", true},
+		{"CCE01BA206240011", "", true}, // 16-char ids need MAC_LENGTHS
+	}
+	for _, tc := range cases {
+		got, err := ParseMAC(tc.in)
+		if (err != nil) != tc.wantErr || strings.ToUpper(hex.EncodeToString(got)) != tc.want {
+			t.Errorf("ParseMAC(%q) = %X, %v", tc.in, got, err)
+		}
+	}
+}
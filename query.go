@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ble-gw-auto-parser/storage"
+)
+
+const (
+	framesDefaultLimit = 20
+	framesMaxLimit     = 200
+)
+
+// GET /frames?mac=CCE01BA20624&limit=20
+func handleFrames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAccess(w, r) || !storeAvailable(w) {
+		return
+	}
+
+	mac, err := ParseMAC(r.URL.Query().Get("mac"))
+	if err != nil {
+		http.Error(w, "bad mac (expect "+macLengthsText()+" hex chars)", http.StatusBadRequest)
+		return
+	}
+	limit := framesDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "bad limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, framesMaxLimit)
+	}
+
+	frames, err := store.RecentByMAC(r.Context(), mac, limit)
+	if err != nil {
+		log.Printf("RecentByMAC err: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"frames": frames})
+}
+
+// GET /last-fix?mac=CCE01BA20624 — last known coordinates, 404 if none.
+func handleLastFix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAccess(w, r) || !storeAvailable(w) {
+		return
+	}
+
+	mac, err := ParseMAC(r.URL.Query().Get("mac"))
+	if err != nil {
+		http.Error(w, "bad mac (expect "+macLengthsText()+" hex chars)", http.StatusBadRequest)
+		return
+	}
+	lat, lon, ts, found, err := store.LastFixByMAC(r.Context(), mac)
+	if err != nil {
+		log.Printf("LastFixByMAC err: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "no fix", http.StatusNotFound)
+		return
+	}
+	out := map[string]any{"lat": lat, "lon": lon, "ts_device": nil}
+	if !ts.IsZero() {
+		out["ts_device"] = ts.UTC()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// fleetDefaultWindow is how far back GET /fleet looks without ?since=.
+const fleetDefaultWindow = 30 * 24 * time.Hour
+
+// GET /fleet?since=2024-06-01T00:00:00Z — gateways seen since then.
+func handleFleet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAccess(w, r) || !storeAvailable(w) {
+		return
+	}
+
+	since := time.Now().Add(-fleetDefaultWindow)
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "bad since (expect RFC3339)", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	gws, err := store.FleetInventory(r.Context(), since)
+	if err != nil {
+		log.Printf("FleetInventory err: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"gateways": gws, "truncated": len(gws) == storage.FleetMaxGateways})
+}
+
+// dailyDefaultDays is the GET /stats/daily window without ?from=.
+const dailyDefaultDays = 30
+
+// GET /stats/daily?from=2024-06-01&to=2024-07-01 — frames per UTC day, to
+// exclusive (default: the last 30 days through today).
+func handleStatsDaily(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAccess(w, r) || !storeAvailable(w) {
+		return
+	}
+
+	to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -dailyDefaultDays)
+	for k, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(k); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				http.Error(w, "bad "+k+" (expect YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if !to.After(from) || to.Sub(from) > storage.DailyMaxDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("bad range (need from < to, at most %d days)", storage.DailyMaxDays), http.StatusBadRequest)
+		return
+	}
+	counts, err := store.DailyCounts(r.Context(), from, to)
+	if err != nil {
+		log.Printf("DailyCounts err: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	// Dense series so charts don't have to fill gaps.
+	days := []map[string]any{}
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format(time.DateOnly)
+		days = append(days, map[string]any{"date": day, "frames": counts[day]})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"from": from.Format(time.DateOnly), "to": to.Format(time.DateOnly), "days": days})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
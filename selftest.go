@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// goldenFrame is a known-good MKGW4 body and what it must decode to.
+type goldenFrame struct {
+	Flag  string
+	Hex   string
+	check func(a *Auto) error
+}
+
+// goldenFixHex: Periodic / GPS fix success at 13.4050,52.5200 (Berlin).
+const goldenFixHex = "00000465F0B6C0" + "01000100" + "02000100" + "030008" + "07FD70D0" + "1F4DEA80"
+
+// goldenFrames cover one frame per registered TLV flag (the nonTLVFlags
+// carry no frame timestamp, so they have none), in the standard framing:
+// deployments with LENGTH_PREFIX_BYTES or TLV_LEN_MODE=inclusive fail them.
+var goldenFrames = []goldenFrame{
+	{"3004", "00000465F0B6C0" + "0200011F" + "0300020F3C", func(a *Auto) error {
+		if a.Status == nil || a.Status.CSQ != 31 || a.Status.BattmV != 3900 {
+			return fmt.Errorf("status %+v", a.Status)
+		}
+		return nil
+	}},
+	{"3089", goldenFixHex, checkGoldenFix},
+	{"30B1", goldenFixHex, checkGoldenFix},
+	{"30A0", "00000465F0B6C0" + "0B0008" + "AABBCCDDEEFFC500", func(a *Auto) error {
+		if len(a.Scan) != 1 || a.Scan[0].MAC != "AABBCCDDEEFF" || a.Scan[0].RSSI != -59 {
+			return fmt.Errorf("scan %+v", a.Scan)
+		}
+		return nil
+	}},
+	{"30C0", "00000465F0B6C0" + "0200011F" + "300013" + goldenFixHex[14:], func(a *Auto) error {
+		if a.Status == nil || a.Status.CSQ != 31 {
+			return fmt.Errorf("status %+v", a.Status)
+		}
+		return checkGoldenFix(a)
+	}},
+	{"30D0", "00000465F0B6C0" + "01000132" + "02000100", func(a *Auto) error {
+		if p := a.OTAProgress; p == nil || p.Percent != 50 || p.Error != 0 {
+			return fmt.Errorf("ota %+v", p)
+		}
+		return nil
+	}},
+}
+
+func checkGoldenFix(a *Auto) error {
+	f := a.Fix
+	if f == nil || f.FixResult != "GPS fix success" ||
+		math.Abs(f.Longitude-13.405) > 1e-6 || math.Abs(f.Latitude-52.52) > 1e-6 {
+		return fmt.Errorf("fix %+v", f)
+	}
+	return nil
+}
+
+// runGolden decodes one golden frame and checks it, including the frame
+// timestamp and that it decoded without warnings. It bypasses the tag
+// metrics so self-test calls don't show up as production traffic.
+func runGolden(g goldenFrame) (*Auto, error) {
+	a, ok, err := decodeMKGW4Auto(g.Flag, g.Hex, false)
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		return nil, fmt.Errorf("flag not recognized")
+	case a.Timestamp != 0x65F0B6C0:
+		return a, fmt.Errorf("timestamp %d", a.Timestamp)
+	case len(a.Warnings) > 0:
+		return a, fmt.Errorf("warnings %v", a.Warnings)
+	}
+	return a, g.check(a)
+}
+
+// GET /selftest — decode the embedded golden frames; 500 if any fails.
+func handleSelftest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAccess(w, r) {
+		return
+	}
+	status := http.StatusOK
+	results := []map[string]any{}
+	for _, g := range goldenFrames {
+		a, err := runGolden(g)
+		res := map[string]any{"flag": g.Flag, "pass": err == nil, "decoded": a}
+		if err != nil {
+			res["error"] = err.Error()
+			status = http.StatusInternalServerError
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, status, map[string]any{"ok": status == http.StatusOK, "frames": results})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoldenFramesPass(t *testing.T) {
+	covered := map[string]bool{}
+	for _, g := range goldenFrames {
+		if _, err := runGolden(g); err != nil {
+			t.Errorf("golden %s: %v", g.Flag, err)
+		}
+		covered[g.Flag] = true
+	}
+	for flag := range flagParsers {
+		if !covered[flag] && !nonTLVFlags[flag] {
+			t.Errorf("flag %s has no golden frame", flag)
+		}
+	}
+}
+
+func TestSelftestEndpoint(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleSelftest(w, httptest.NewRequest(http.MethodGet, "/selftest", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /selftest = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSelftestSkipsTagMetrics(t *testing.T) {
+	before := metricTotal(tlvTagsSeen)
+	for _, g := range goldenFrames {
+		if _, err := runGolden(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := metricTotal(tlvTagsSeen); after != before {
+		t.Errorf("self-test counted %g tags", after-before)
+	}
+}
+
+func metricTotal(m *metric) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sum float64
+	for _, v := range m.series {
+		sum += v
+	}
+	return sum
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// seqTracker remembers the last frame sequence number per gw_mac so we can
+// flag lost uplinks. It is per-instance and bounded: when full, an arbitrary
+// entry is evicted (worst case we miss one gap for that gateway).
+type seqTracker struct {
+	mu   sync.Mutex
+	max  int
+	last map[string]int
+}
+
+const seqTrackerMax = 10000
+
+var seqs = &seqTracker{max: seqTrackerMax, last: map[string]int{}}
+
+// observe records seq for mac and returns how many frames were skipped since
+// the previous one (0 for the first frame, an in-order frame, or a repeat).
+// Sequence numbers are uint16 and wrap 65535 -> 0; a step more than half the
+// range forward (i.e. backwards) is a reboot/reset, not a wrap, and reports
+// reset with no gap.
+func (t *seqTracker) observe(mac string, seq int) (gap int, reset bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev, seen := t.last[mac]
+	if !seen && len(t.last) >= t.max {
+		for k := range t.last {
+			delete(t.last, k)
+			break
+		}
+	}
+	t.last[mac] = seq
+	if !seen || seq == prev {
+		return 0, false
+	}
+	step := (seq - prev + 0x10000) & 0xFFFF
+	if step >= 0x8000 {
+		return 0, true
+	}
+	return step - 1, false
+}
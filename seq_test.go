@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestSeqTrackerObserve(t *testing.T) {
+	tests := []struct {
+		name      string
+		seqs      []int
+		wantGap   int
+		wantReset bool
+	}{
+		{"first frame", []int{7}, 0, false},
+		{"increment", []int{7, 8}, 0, false},
+		{"repeat", []int{7, 7}, 0, false},
+		{"gap", []int{7, 10}, 2, false},
+		{"wraparound", []int{65535, 0}, 0, false},
+		{"wraparound with gap", []int{65534, 1}, 2, false},
+		{"reboot", []int{500, 1}, 0, true},
+		{"just under half range", []int{0, 0x7FFF}, 0x7FFE, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &seqTracker{max: 10, last: map[string]int{}}
+			var gap int
+			var reset bool
+			for _, s := range tt.seqs {
+				gap, reset = tr.observe("CCE01BA20624", s)
+			}
+			if gap != tt.wantGap || reset != tt.wantReset {
+				t.Errorf("observe(%v) = %d, %v; want %d, %v", tt.seqs, gap, reset, tt.wantGap, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestSeqTrackerPerMAC(t *testing.T) {
+	tr := &seqTracker{max: 10, last: map[string]int{}}
+	tr.observe("A", 1)
+	tr.observe("B", 100)
+	if gap, _ := tr.observe("A", 2); gap != 0 {
+		t.Errorf("gateway A gap = %d, want 0 (B must not interfere)", gap)
+	}
+}
+
+func TestSeqTrackerBounded(t *testing.T) {
+	tr := &seqTracker{max: 2, last: map[string]int{}}
+	for _, mac := range []string{"A", "B", "C", "D"} {
+		tr.observe(mac, 1)
+	}
+	if n := len(tr.last); n > 2 {
+		t.Errorf("tracker holds %d gateways, want <= 2", n)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"ble-gw-auto-parser/db"
@@ -16,34 +18,97 @@ import (
 
 type Store struct {
 	pool *pgxpool.Pool
+	read *pgxpool.Pool // read-only queries; the replica when configured, else pool
+
+	backfillMu    sync.Mutex
+	backfillAfter int64 // BackfillDenormFromJSON resumes after this id
 }
 
 func New() *Store {
-	return &Store{pool: db.Pool} // uses the global Pool from db.Connect()
+	return newStore(db.Pool, db.ReadPool) // uses the global pools from db.Connect()
+}
+
+// newStore falls back to pool for reads when there is no replica.
+func newStore(pool, read *pgxpool.Pool) *Store {
+	if read == nil {
+		read = pool
+	}
+	return &Store{pool: pool, read: read}
 }
 
 // Type aliases to reuse parser types without import cycles (storage ↔ parser):
 type AutoStatus = struct {
-	NetworkType string
-	CSQ         int
-	BattmV      int
-	AxisXmg     int
-	AxisYmg     int
-	AxisZmg     int
-	AccStatus   int
-	IMEI        string
-	ICCID       string
+	NetworkType       string
+	CSQ               int
+	BattmV            int
+	AxisXmg           int
+	AxisYmg           int
+	AxisZmg           int
+	AccStatus         int
+	IMEI              string
+	ICCID             string
+	TempC             *float64
+	Humidity          *int
+	BootCount         *int64
+	UptimeSec         *int64
+	BattTempC         *int
+	RSRP              *int
+	RSRQ              *int
+	PLMN              *string
+	ConfigVersion     *int
+	BoardTempC        *float64
+	LinkQuality       *int
+	ReportIntervalSec *int
+	PendingDownlinks  *int
+	MotionEvents      *int
+	MovingSec         *int
+	PowerSource       *string
+	ChargingState     *string
+	AxisMagnitude     *float64
+	TiltDegrees       *float64
 }
 type AutoFix = struct {
-	FixMode   string
-	FixResult string
-	Longitude float64
-	Latitude  float64
-	TacLac    int
-	CI        int64
+	FixMode     string
+	FixResult   string
+	Longitude   float64
+	Latitude    float64
+	TacLac      int
+	CI          int64
+	HasPosition bool // false -> latitude/longitude written as NULL
+	HasCell     bool // false -> tac/cell_id written as NULL
+	HasTacLac   bool // false -> tac written as NULL even with a cell id
+	AccuracyM   *int
+	LAC         *int
+	Geohash     *string
+}
+
+// denormColumns are the columns updateParsedAndDenorm derives from the
+// decode (everything but parser, parser_json and ts_device).
+var denormColumns = []string{
+	"latitude", "longitude", "tac", "cell_id",
+	"network_type", "csq", "batt_mv", "axis_x_mg", "axis_y_mg", "axis_z_mg", "acc_status", "imei", "iccid",
+	"temp_c", "humidity", "boot_count", "uptime_sec", "batt_temp_c",
+	"parser_warnings",
+	"rsrp", "rsrq",
+	"plmn",
+	"config_version",
+	"accuracy_m",
+	"board_temp_c",
+	"link_quality",
+	"report_interval_sec",
+	"lac",
+	"geohash",
+	"pending_downlinks",
+	"motion_events",
+	"moving_sec",
+	"power_source",
+	"charging_state",
+	"axis_magnitude",
+	"tilt_deg",
 }
 
 // Update parsed JSON AND denormalized columns into the SAME row.
+// warnings go to parser_warnings (text[], NULL when none).
 func (s *Store) UpdateGatewayParsedAndDenormByID(
 	ctx context.Context,
 	id int64,
@@ -52,11 +117,90 @@ func (s *Store) UpdateGatewayParsedAndDenormByID(
 	deviceTs time.Time,
 	st *AutoStatus,
 	fx *AutoFix,
+	warnings []string,
+) error {
+	return updateParsedAndDenorm(ctx, s.pool, id, parser, parsed, deviceTs, st, fx, warnings)
+}
+
+func updateParsedAndDenorm(
+	ctx context.Context,
+	q querier,
+	id int64,
+	parser string,
+	parsed any,
+	deviceTs time.Time,
+	st *AutoStatus,
+	fx *AutoFix,
+	warnings []string,
 ) error {
 	b, _ := json.Marshal(parsed)
 
-	// Precompute all nullable params as `any` so nil stays nil and COALESCE works.
-	// Build nullable values
+	// We update ts_device to decoded deviceTs if not zero.
+	var tsDev *time.Time
+	if !deviceTs.IsZero() {
+		tmp := deviceTs.UTC()
+		tsDev = &tmp
+	}
+
+	// Note: use COALESCE to allow nulls; we set explicitely whatever we have now.
+	ct, err := q.Exec(ctx, `
+		UPDATE public.gateway_message
+		SET 
+			parser			= $2,
+			parser_json		= $3,
+			ts_device		= COALESCE($4, ts_device),
+			latitude		= $5,
+			longitude		= $6,
+			tac				= $7,
+			cell_id			= $8,
+			network_type	= $9,
+			csq				= $10,
+			batt_mv			= $11,
+			axis_x_mg		= $12,
+			axis_y_mg		= $13,
+			axis_z_mg		= $14,
+			acc_status		= $15,
+			imei			= $16,
+			iccid			= $17,
+			temp_c			= $18,
+			humidity		= $19,
+			boot_count		= $20,
+			uptime_sec		= $21,
+			batt_temp_c		= $22,
+			parser_warnings	= $23,
+			rsrp			= $24,
+			rsrq			= $25,
+			plmn			= $26,
+			config_version	= $27,
+			accuracy_m		= $28,
+			board_temp_c	= $29,
+			link_quality	= $30,
+			report_interval_sec	= $31,
+			lac				= $32,
+			geohash			= $33,
+			pending_downlinks	= $34,
+			motion_events	= $35,
+			moving_sec		= $36,
+			power_source	= $37,
+			charging_state	= $38,
+			axis_magnitude	= $39,
+			tilt_deg		= $40
+		WHERE id = $1
+	`, append([]any{id, parser, json.RawMessage(b), tsDev}, denormValues(st, fx, warnings)...)...)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+
+}
+
+// denormValues returns the denorm column values for a decode, in
+// denormColumns order; nil stays nil so the column is written NULL.
+func denormValues(st *AutoStatus, fx *AutoFix, warnings []string) []any {
 	var (
 		lat, lon *float64
 		tac, csq *int
@@ -66,10 +210,16 @@ func (s *Store) UpdateGatewayParsedAndDenormByID(
 	var batt, ax, ay, az, acc *int
 
 	if fx != nil {
-		lon = &fx.Longitude
-		lat = &fx.Latitude
-		tac = &fx.TacLac
-		ci = &fx.CI
+		if fx.HasPosition {
+			lon = &fx.Longitude
+			lat = &fx.Latitude
+		}
+		if fx.HasCell {
+			if fx.HasTacLac && fx.LAC == nil { // a LAC goes to its own column, not tac
+				tac = &fx.TacLac
+			}
+			ci = &fx.CI
+		}
 	}
 
 	if st != nil {
@@ -80,7 +230,7 @@ func (s *Store) UpdateGatewayParsedAndDenormByID(
 			imei = &st.IMEI
 		}
 		if st.ICCID != "" {
-			imei = &st.ICCID
+			iccid = &st.ICCID
 		}
 		c := st.CSQ
 		b := st.BattmV
@@ -97,48 +247,28 @@ func (s *Store) UpdateGatewayParsedAndDenormByID(
 		acc = &a
 	}
 
-	// We update ts_device to decoded deviceTs if not zero.
-	var tsDev *time.Time
-	if !deviceTs.IsZero() {
-		tmp := deviceTs.UTC()
-		tsDev = &tmp
+	// Optional status/fix columns are pointers already (nil -> NULL); read
+	// them through a zero value so a missing status/fix still binds NULLs.
+	sx := st
+	if sx == nil {
+		sx = &AutoStatus{}
 	}
-
-	// Note: use COALESCE to allow nulls; we set explicitely whatever we have now.
-	ct, err := s.pool.Exec(ctx, `
-		UPDATE public.gateway_message
-		SET 
-			parser			= $2,
-			parser_json		= $3,
-			ts_device		= COALESCE($4, ts_device),
-			latitude		= $5,
-			longitude		= $6,
-			tac				= $7,
-			cell_id			= $8,
-			network_type	= $9,
-			csq				= $10,
-			batt_mv			= $11,
-			axis_x_mg		= $12,
-			axis_y_mg		= $13,
-			axis_z_mg		= $14,
-			acc_status		= $15,
-			imei			= $16,
-			iccid			= $17
-		WHERE id = $1
-	`, id, parser, json.RawMessage(b),
-		tsDev,
+	fxx := fx
+	if fxx == nil {
+		fxx = &AutoFix{}
+	}
+	return []any{
 		lat, lon, tac, ci,
 		netType, csq, batt, ax, ay, az, acc, imei, iccid,
-	)
-	if err != nil {
-		return err
+		sx.TempC, sx.Humidity, sx.BootCount, sx.UptimeSec, sx.BattTempC,
+		warnings,
+		sx.RSRP, sx.RSRQ, sx.PLMN, sx.ConfigVersion,
+		fxx.AccuracyM,
+		sx.BoardTempC, sx.LinkQuality, sx.ReportIntervalSec,
+		fxx.LAC, fxx.Geohash,
+		sx.PendingDownlinks, sx.MotionEvents, sx.MovingSec, sx.PowerSource, sx.ChargingState, sx.AxisMagnitude,
+		sx.TiltDegrees,
 	}
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
-	}
-
-	return nil
-
 }
 
 func (s *Store) UpdateGatewayParsedColumnsByID(
@@ -170,9 +300,14 @@ func (s *Store) UpdateGatewayParsedColumnsByID(
 }
 
 // Update parser output into the SAME row in public.gateway_message
+// Denorm columns are left as they are.
 func (s *Store) UpdateGatewayParsedByID(ctx context.Context, id int64, parser string, parsed any) error {
+	return updateParsed(ctx, s.pool, id, parser, parsed)
+}
+
+func updateParsed(ctx context.Context, q querier, id int64, parser string, parsed any) error {
 	b, _ := json.Marshal(parsed)
-	ct, err := s.pool.Exec(ctx, `
+	ct, err := q.Exec(ctx, `
         UPDATE public.gateway_message
         SET parser = $2,
             parser_json = $3
@@ -224,3 +359,450 @@ func (s *Store) FindGatewayRowID(ctx context.Context, gwMAC []byte, ts time.Time
 	}
 	return 0, pgx.ErrNoRows
 }
+
+// FrameSummary is a compact view of one gateway_message row for troubleshooting.
+type FrameSummary struct {
+	ID         int64           `json:"id"`
+	TsDevice   *time.Time      `json:"ts_device"`
+	Flag       string          `json:"flag"`
+	Parser     string          `json:"parser"`
+	ParserJSON json.RawMessage `json:"parser_json,omitempty"`
+}
+
+// RecentByMAC returns the latest frames for a gateway, newest first.
+func (s *Store) RecentByMAC(ctx context.Context, gwMAC []byte, limit int) ([]FrameSummary, error) {
+	rows, err := s.read.Query(ctx, `
+        SELECT id, ts_device,
+               COALESCE(parser_json->>'flag', ''),
+               COALESCE(parser, ''),
+               parser_json
+        FROM public.gateway_message
+        WHERE gw_mac = $1
+        ORDER BY id DESC
+        LIMIT $2
+    `, gwMAC, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFrameSummaries(rows)
+}
+
+// ParsedByIdempotencyKey follows the receipt to its row and returns that row's
+// parser_json; pgx.ErrNoRows when the key is unknown, had no row_id, or the
+// row is gone/unparsed. With per-gw_mac receipts the newest match wins. Reads
+// the primary so a just-ingested key is visible.
+func (s *Store) ParsedByIdempotencyKey(ctx context.Context, key string) (json.RawMessage, error) {
+	var out json.RawMessage
+	err := s.pool.QueryRow(ctx, `
+        SELECT m.parser_json
+        FROM gw_auto_receipts r
+        JOIN public.gateway_message m ON m.id = r.row_id
+        WHERE r.idempotency_key = $1 AND m.parser_json IS NOT NULL
+        ORDER BY r.created_at DESC
+        LIMIT 1
+    `, key).Scan(&out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LastFixByMAC returns the newest row for gw_mac that has coordinates.
+// found is false (with a nil error) when the gateway never reported a fix.
+func (s *Store) LastFixByMAC(ctx context.Context, gwMAC []byte) (lat, lon float64, ts time.Time, found bool, err error) {
+	var tsDev *time.Time
+	err = s.read.QueryRow(ctx, `
+        SELECT latitude, longitude, ts_device
+        FROM public.gateway_message
+        WHERE gw_mac = $1 AND latitude IS NOT NULL AND longitude IS NOT NULL
+        ORDER BY id DESC
+        LIMIT 1
+    `, gwMAC).Scan(&lat, &lon, &tsDev)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, time.Time{}, false, nil
+	}
+	if err != nil {
+		return 0, 0, time.Time{}, false, err
+	}
+	if tsDev != nil {
+		ts = *tsDev
+	}
+	return lat, lon, ts, true, nil
+}
+
+func scanFrameSummaries(rows pgx.Rows) ([]FrameSummary, error) {
+	defer rows.Close()
+
+	out := []FrameSummary{}
+	for rows.Next() {
+		var f FrameSummary
+		var pj []byte
+		if err := rows.Scan(&f.ID, &f.TsDevice, &f.Flag, &f.Parser, &pj); err != nil {
+			return nil, err
+		}
+		if len(pj) > 0 {
+			f.ParserJSON = json.RawMessage(pj)
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// RowsWithWarnings returns recent rows whose parse recorded warnings, newest first.
+func (s *Store) RowsWithWarnings(ctx context.Context, since time.Time, limit int) ([]FrameSummary, error) {
+	rows, err := s.read.Query(ctx, `
+        SELECT id, ts_device,
+               COALESCE(parser_json->>'flag', ''),
+               COALESCE(parser, ''),
+               parser_json
+        FROM public.gateway_message
+        WHERE cardinality(parser_warnings) > 0
+          AND ts_device >= $1
+        ORDER BY id DESC
+        LIMIT $2
+    `, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFrameSummaries(rows)
+}
+
+// GatewaySummary is one fleet inventory entry.
+type GatewaySummary struct {
+	GWMAC      string     `json:"gw_mac"`
+	GWHW       string     `json:"gw_hw"` // from parser_json; "" if never parsed
+	LastSeen   *time.Time `json:"last_seen"`
+	FrameCount int64      `json:"frame_count"`
+}
+
+// FleetMaxGateways bounds FleetInventory.
+const FleetMaxGateways = 10000
+
+// FleetInventory lists every gateway with frames since the cutoff, most
+// recently seen first, capped at FleetMaxGateways.
+func (s *Store) FleetInventory(ctx context.Context, since time.Time) ([]GatewaySummary, error) {
+	rows, err := s.read.Query(ctx, `
+        SELECT upper(encode(gw_mac, 'hex')),
+               COALESCE(max(parser_json->>'gw_hw'), ''),
+               max(ts_device),
+               count(*)
+        FROM public.gateway_message
+        WHERE ts_device >= $1
+        GROUP BY gw_mac
+        ORDER BY max(ts_device) DESC
+        LIMIT $2
+    `, since, FleetMaxGateways)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []GatewaySummary{}
+	for rows.Next() {
+		var g GatewaySummary
+		if err := rows.Scan(&g.GWMAC, &g.GWHW, &g.LastSeen, &g.FrameCount); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// DailyMaxDays bounds the DailyCounts range.
+const DailyMaxDays = 366
+
+// DailyCounts returns frames per UTC day ("2006-01-02" -> count) with
+// ts_device in [from, to). Days without frames are absent.
+func (s *Store) DailyCounts(ctx context.Context, from, to time.Time) (map[string]int64, error) {
+	rows, err := s.read.Query(ctx, `
+        SELECT to_char(date_trunc('day', ts_device AT TIME ZONE 'UTC'), 'YYYY-MM-DD'), count(*)
+        FROM public.gateway_message
+        WHERE ts_device >= $1 AND ts_device < $2
+        GROUP BY 1
+    `, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]int64{}
+	for rows.Next() {
+		var day string
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		out[day] = n
+	}
+	return out, rows.Err()
+}
+
+// MarkPublished stamps published_at once the downstream publish was acked.
+func (s *Store) MarkPublished(ctx context.Context, id int64) error {
+	return markPublished(ctx, s.pool, id)
+}
+
+func markPublished(ctx context.Context, q querier, id int64) error {
+	ct, err := q.Exec(ctx, `
+        UPDATE public.gateway_message SET published_at = now() WHERE id = $1
+    `, id)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// UnpublishedRows returns parsed rows never acked downstream, oldest first,
+// for the reconciliation job. before skips rows whose publish may still be
+// in flight.
+func (s *Store) UnpublishedRows(ctx context.Context, before time.Time, limit int) ([]FrameSummary, error) {
+	rows, err := s.read.Query(ctx, `
+        SELECT id, ts_device,
+               COALESCE(parser_json->>'flag', ''),
+               COALESCE(parser, ''),
+               parser_json
+        FROM public.gateway_message
+        WHERE parser IS NOT NULL
+          AND published_at IS NULL
+          AND ts_device < $1
+        ORDER BY id
+        LIMIT $2
+    `, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFrameSummaries(rows)
+}
+
+// BackfillDenormFromJSON fills NULL denorm columns from parser_json for rows
+// parsed before the columns existed, batchSize rows per UPDATE, and returns
+// how many rows it processed. The Store remembers the last id reached, so a
+// later call resumes there and never revisits rows whose JSON had nothing
+// to fill (and which therefore still look un-backfilled). Existing column
+// values are never overwritten. tac/lac follow updateParsedAndDenorm: a fix
+// with a "lac" key fills lac and leaves tac NULL.
+func (s *Store) BackfillDenormFromJSON(ctx context.Context, batchSize int) (int64, error) {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	processed, lastID, err := s.backfillDenormFromJSON(ctx, s.backfillAfter, batchSize)
+	s.backfillAfter = lastID
+	return processed, err
+}
+
+// backfillDenormFromJSON walks ids after afterID; lastID is the cursor to
+// resume from, advanced even when it stops on an error.
+func (s *Store) backfillDenormFromJSON(ctx context.Context, afterID int64, batchSize int) (processed, lastID int64, err error) {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	lastID = afterID
+	for {
+		rows, err := s.pool.Query(ctx, `
+			WITH batch AS (
+				SELECT id
+				FROM public.gateway_message
+				WHERE id > $1
+				  AND parser_json IS NOT NULL
+				  AND (parser_json ? 'status' OR parser_json ? 'fix')
+				  AND latitude IS NULL AND cell_id IS NULL AND csq IS NULL AND network_type IS NULL
+				ORDER BY id
+				LIMIT $2
+			)
+			UPDATE public.gateway_message g
+			SET
+				latitude		= COALESCE(g.latitude, (g.parser_json->'fix'->>'lat')::float8),
+				longitude		= COALESCE(g.longitude, (g.parser_json->'fix'->>'lon')::float8),
+				tac				= COALESCE(g.tac, CASE WHEN g.parser_json->'fix' ? 'lac' THEN NULL
+									ELSE (g.parser_json->'fix'->>'tac_lac')::int END),
+				lac				= COALESCE(g.lac, (g.parser_json->'fix'->>'lac')::int),
+				cell_id			= COALESCE(g.cell_id, (g.parser_json->'fix'->>'ci')::bigint),
+				network_type	= COALESCE(g.network_type, NULLIF(g.parser_json->'status'->>'network_type', '')),
+				csq				= COALESCE(g.csq, (g.parser_json->'status'->>'csq')::int),
+				batt_mv			= COALESCE(g.batt_mv, (g.parser_json->'status'->>'batt_mv')::int),
+				axis_x_mg		= COALESCE(g.axis_x_mg, (g.parser_json->'status'->>'axis_x_mg')::int),
+				axis_y_mg		= COALESCE(g.axis_y_mg, (g.parser_json->'status'->>'axis_y_mg')::int),
+				axis_z_mg		= COALESCE(g.axis_z_mg, (g.parser_json->'status'->>'axis_z_mg')::int),
+				acc_status		= COALESCE(g.acc_status, (g.parser_json->'status'->>'acc_status')::int),
+				imei			= COALESCE(g.imei, NULLIF(g.parser_json->'status'->>'imei', '')),
+				iccid			= COALESCE(g.iccid, NULLIF(g.parser_json->'status'->>'iccid', ''))
+			FROM batch
+			WHERE g.id = batch.id
+			RETURNING g.id
+		`, lastID, batchSize)
+		if err != nil {
+			return processed, lastID, fmt.Errorf("backfill after id %d: %w", lastID, err)
+		}
+		var n int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return processed, lastID, err
+			}
+			n++
+			lastID = max(lastID, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return processed, lastID, err
+		}
+		processed += n
+		if n < int64(batchSize) {
+			return processed, lastID, nil
+		}
+	}
+}
+
+// PurgeOrphanReceipts deletes idempotency receipts created before the cutoff
+// that point at no row (row_id NULL, or the row has since been deleted) and
+// returns how many went. Past the clients' retry window such a receipt can
+// no longer dedupe anything. Receipts whose row still exists are kept for
+// ParsedByIdempotencyKey. Assumes gw_auto_receipts.created_at (timestamptz
+// DEFAULT now()).
+func (s *Store) PurgeOrphanReceipts(ctx context.Context, before time.Time) (int64, error) {
+	ct, err := s.pool.Exec(ctx, `
+        DELETE FROM gw_auto_receipts r
+        WHERE r.created_at < $1
+          AND (r.row_id IS NULL OR NOT EXISTS (
+                SELECT 1 FROM public.gateway_message m WHERE m.id = r.row_id))
+    `, before)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// DeleteByMACPrefix deletes every row whose gw_mac starts with prefix (load
+// test gateways use a reserved prefix) and returns the count. An empty prefix
+// is refused rather than wiping the table.
+func (s *Store) DeleteByMACPrefix(ctx context.Context, prefix []byte) (int64, error) {
+	if len(prefix) == 0 {
+		return 0, errors.New("empty mac prefix")
+	}
+	ct, err := s.pool.Exec(ctx, `
+        DELETE FROM public.gateway_message
+        WHERE substring(gw_mac FROM 1 FOR $2) = $1
+    `, prefix, len(prefix))
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// ClearParsedByID "unparses" a row: parser, parser_json and every denorm
+// column go back to NULL so a reprocess starts clean. ts_device is kept.
+func (s *Store) ClearParsedByID(ctx context.Context, id int64) error {
+	set := []string{"parser = NULL", "parser_json = NULL"}
+	for _, c := range denormColumns {
+		set = append(set, c+" = NULL")
+	}
+	ct, err := s.pool.Exec(ctx, `
+        UPDATE public.gateway_message
+        SET `+strings.Join(set, ", ")+`
+        WHERE id = $1
+    `, id)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// InsertRow is one new gateway_message row for CopyInsertParsed.
+type InsertRow struct {
+	GWMAC      []byte
+	TsDevice   time.Time
+	PayloadHex string
+	Parser     string
+	Parsed     any // marshalled into parser_json
+	Status     *AutoStatus
+	Fix        *AutoFix
+	Warnings   []string
+}
+
+// CopyInsertParsed bulk-inserts rows (denorm columns included) in one tx
+// and returns their ids in row order; see Tx.CopyInsertParsed.
+func (s *Store) CopyInsertParsed(ctx context.Context, rows []InsertRow) ([]int64, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+	ids, err := copyInsertParsed(ctx, tx, rows)
+	if err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit(ctx)
+}
+
+// copyInsertColumns are the gateway_message columns CopyInsertParsed fills.
+var copyInsertColumns = append([]string{"gw_mac", "ts_device", "payload_hex", "parser", "parser_json"}, denormColumns...)
+
+// insertValues returns r's values in copyInsertColumns order.
+func insertValues(r InsertRow) ([]any, error) {
+	b, err := json.Marshal(r.Parsed)
+	if err != nil {
+		return nil, fmt.Errorf("marshal parsed: %w", err)
+	}
+	return append([]any{r.GWMAC, r.TsDevice.UTC(), r.PayloadHex, r.Parser, json.RawMessage(b)},
+		denormValues(r.Status, r.Fix, r.Warnings)...), nil
+}
+
+// copyInsertParsed COPYs rows into a temp table shaped like gateway_message,
+// then moves them over with INSERT ... SELECT, since COPY itself has no
+// RETURNING. The SELECT runs in row order, so the serial ids come out
+// ascending in that same order.
+func copyInsertParsed(ctx context.Context, tx pgx.Tx, rows []InsertRow) ([]int64, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	src := make([][]any, 0, len(rows))
+	for i, r := range rows {
+		vals, err := insertValues(r)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		src = append(src, append([]any{i}, vals...))
+	}
+
+	cols := strings.Join(copyInsertColumns, ", ")
+	if _, err := tx.Exec(ctx, `
+		CREATE TEMP TABLE gw_copy_insert ON COMMIT DROP AS
+		SELECT 0 AS ord, `+cols+` FROM public.gateway_message WITH NO DATA
+	`); err != nil {
+		return nil, fmt.Errorf("copy staging table: %w", err)
+	}
+	if _, err := tx.CopyFrom(ctx,
+		pgx.Identifier{"gw_copy_insert"},
+		append([]string{"ord"}, copyInsertColumns...),
+		pgx.CopyFromRows(src),
+	); err != nil {
+		return nil, fmt.Errorf("copy: %w", err)
+	}
+	r, err := tx.Query(ctx, `
+		INSERT INTO public.gateway_message (`+cols+`)
+		SELECT `+cols+` FROM gw_copy_insert ORDER BY ord
+		RETURNING id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("insert from copy: %w", err)
+	}
+	ids, err := pgx.CollectRows(r, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("insert from copy: %w", err)
+	}
+	if len(ids) != len(rows) {
+		return nil, fmt.Errorf("insert from copy: %d ids for %d rows", len(ids), len(rows))
+	}
+	slices.Sort(ids)
+	// Dropped now rather than at commit, so a second batch in this tx works.
+	if _, err := tx.Exec(ctx, `DROP TABLE gw_copy_insert`); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
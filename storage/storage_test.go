@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func copyRows(n int) []InsertRow {
+	rows := make([]InsertRow, n)
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range rows {
+		tac := 4660
+		rows[i] = InsertRow{
+			GWMAC:      []byte{0xCC, 0xE0, 0x1B, 0xA2, 0x06, 0x24},
+			TsDevice:   ts.Add(time.Duration(i) * time.Second),
+			PayloadHex: fmt.Sprintf("%04X", i),
+			Parser:     "mkgw4:auto",
+			Parsed:     map[string]any{"seq_no": i},
+			Status:     &AutoStatus{NetworkType: "LTE-M", CSQ: i % 32, BattmV: 3900},
+			Fix:        &AutoFix{Latitude: 48.1, Longitude: 11.5, HasPosition: true, HasCell: true, HasTacLac: true, TacLac: tac, CI: int64(i)},
+		}
+	}
+	return rows
+}
+
+func TestCopyInsertParsed1000Rows(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	rows := copyRows(1000)
+
+	ids, err := s.CopyInsertParsed(ctx, rows)
+	if err != nil {
+		t.Fatalf("copy insert: %v", err)
+	}
+	if len(ids) != len(rows) {
+		t.Fatalf("%d ids for %d rows", len(ids), len(rows))
+	}
+	// ids[i] must be rows[i]: check a spread of them column by column.
+	for _, i := range []int{0, 1, 499, 998, 999} {
+		var payload, netType string
+		var seq, csq, tac int
+		var ci int64
+		var lat float64
+		err := s.pool.QueryRow(ctx, `
+			SELECT payload_hex, (parser_json->>'seq_no')::int, network_type, csq, tac, cell_id, latitude
+			FROM public.gateway_message WHERE id = $1
+		`, ids[i]).Scan(&payload, &seq, &netType, &csq, &tac, &ci, &lat)
+		if err != nil {
+			t.Fatalf("row %d (id %d): %v", i, ids[i], err)
+		}
+		if payload != rows[i].PayloadHex || seq != i || ci != int64(i) || csq != i%32 {
+			t.Errorf("id %d holds payload %s seq %d ci %d csq %d, want row %d", ids[i], payload, seq, ci, csq, i)
+		}
+		if netType != "LTE-M" || tac != 4660 || lat != 48.1 {
+			t.Errorf("id %d denorm: network_type %q tac %d lat %g", ids[i], netType, tac, lat)
+		}
+	}
+	var n int
+	if err := s.pool.QueryRow(ctx, `SELECT count(*) FROM public.gateway_message`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(rows) {
+		t.Errorf("table has %d rows, want %d", n, len(rows))
+	}
+}
+
+func TestCopyInsertParsedTwiceInOneTx(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	a, err := tx.CopyInsertParsed(ctx, copyRows(3))
+	if err != nil {
+		t.Fatalf("first batch: %v", err)
+	}
+	b, err := tx.CopyInsertParsed(ctx, copyRows(2))
+	if err != nil {
+		t.Fatalf("second batch: %v", err)
+	}
+	if len(a) != 3 || len(b) != 2 || b[0] <= a[2] {
+		t.Errorf("ids %v then %v", a, b)
+	}
+}
+
+func TestInsertParsedMatchesCopy(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	row := copyRows(1)[0]
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	one, err := tx.InsertParsed(ctx, row)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	bulk, err := tx.CopyInsertParsed(ctx, []InsertRow{row})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	var same bool
+	err = tx.tx.QueryRow(ctx, `
+		SELECT (to_jsonb(a) - 'id') = (to_jsonb(b) - 'id')
+		FROM public.gateway_message a, public.gateway_message b
+		WHERE a.id = $1 AND b.id = $2
+	`, one, bulk[0]).Scan(&same)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Error("InsertParsed and CopyInsertParsed wrote different rows")
+	}
+}
+
+func TestSetReceiptRows(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	for _, k := range []string{"a", "b"} {
+		if _, err := tx.InsertReceipt(ctx, k, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err := tx.CopyInsertParsed(ctx, copyRows(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.SetReceiptRows(ctx, nil, []string{"a", "b"}, ids); err != nil {
+		t.Fatalf("set receipt rows: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// ParsedByIdempotencyKey follows the receipt to its row.
+	raw, err := s.ParsedByIdempotencyKey(ctx, "b")
+	if err != nil {
+		t.Fatalf("parsed by key: %v", err)
+	}
+	if string(raw) != `{"seq_no": 1}` {
+		t.Errorf("key b -> %s, want row 1", raw)
+	}
+}
+
+func benchmarkInsert(b *testing.B, bulk bool) {
+	s := testStore(b)
+	ctx := context.Background()
+	rows := copyRows(500)
+	for b.Loop() {
+		tx, err := s.Begin(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if bulk {
+			_, err = tx.CopyInsertParsed(ctx, rows)
+		} else {
+			for _, r := range rows {
+				if _, err = tx.InsertParsed(ctx, r); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertParsedPerRow(b *testing.B) { benchmarkInsert(b, false) }
+func BenchmarkCopyInsertParsed(b *testing.B)   { benchmarkInsert(b, true) }
+
+func TestUpdateParsedAndDenormNullability(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	lac := 77
+	cases := []struct {
+		name    string
+		fx      *AutoFix
+		wantTac *int
+		wantLac *int
+		wantCI  *int64
+		wantLat bool
+	}{
+		{"full fix", &AutoFix{HasPosition: true, Latitude: 1, Longitude: 2, HasCell: true, HasTacLac: true, TacLac: 5, CI: 9}, ptr(5), nil, ptr(int64(9)), true},
+		{"ci without tac", &AutoFix{HasCell: true, CI: 9}, nil, nil, ptr(int64(9)), false},
+		{"lac goes to its column", &AutoFix{HasCell: true, HasTacLac: true, TacLac: 77, CI: 9, LAC: &lac}, nil, &lac, ptr(int64(9)), false},
+		{"no cell", &AutoFix{HasPosition: true, Latitude: 1, Longitude: 2}, nil, nil, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			id := insertParsedRow(t, s)
+			if err := s.UpdateGatewayParsedAndDenormByID(ctx, id, "test", map[string]any{}, time.Now(), nil, tc.fx, nil); err != nil {
+				t.Fatal(err)
+			}
+			var tac, lacCol *int
+			var ci *int64
+			var lat *float64
+			if err := s.pool.QueryRow(ctx, `SELECT tac, lac, cell_id, latitude FROM public.gateway_message WHERE id = $1`, id).
+				Scan(&tac, &lacCol, &ci, &lat); err != nil {
+				t.Fatal(err)
+			}
+			if !eqPtr(tac, tc.wantTac) || !eqPtr(lacCol, tc.wantLac) || !eqPtr(ci, tc.wantCI) || (lat != nil) != tc.wantLat {
+				t.Errorf("tac %v lac %v ci %v lat %v", deref(tac), deref(lacCol), deref(ci), deref(lat))
+			}
+		})
+	}
+}
+
+func TestUpdateParsedKeepsDenormColumns(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	id := insertParsedRow(t, s)
+	st := &AutoStatus{NetworkType: "NB-IoT", CSQ: 20}
+	if err := s.UpdateGatewayParsedAndDenormByID(ctx, id, "a", map[string]any{}, time.Now(), st, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateGatewayParsedByID(ctx, id, "b", map[string]any{"v": 2}); err != nil {
+		t.Fatal(err)
+	}
+	var parser, netType string
+	if err := s.pool.QueryRow(ctx, `SELECT parser, network_type FROM public.gateway_message WHERE id = $1`, id).Scan(&parser, &netType); err != nil {
+		t.Fatal(err)
+	}
+	if parser != "b" || netType != "NB-IoT" {
+		t.Errorf("parser %q network_type %q", parser, netType)
+	}
+}
+
+func TestUpdateMissingRowIsErrNoRows(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	if err := s.UpdateGatewayParsedAndDenormByID(ctx, 424242, "x", map[string]any{}, time.Now(), nil, nil, nil); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("update: %v, want ErrNoRows", err)
+	}
+	if err := s.MarkPublished(ctx, 424242); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("mark published: %v, want ErrNoRows", err)
+	}
+	if err := s.ClearParsedByID(ctx, 424242); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("clear parsed: %v, want ErrNoRows", err)
+	}
+}
+
+func TestPurgeOrphanReceipts(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	live := insertParsedRow(t, s)
+	gone := insertParsedRow(t, s)
+	if _, err := s.pool.Exec(ctx, `
+		INSERT INTO gw_auto_receipts (idempotency_key, row_id, created_at) VALUES
+			('old-null', NULL, now() - interval '2 days'),
+			('old-live', $1, now() - interval '2 days'),
+			('old-gone', $2, now() - interval '2 days'),
+			('new-null', NULL, now())
+	`, live, gone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.pool.Exec(ctx, `DELETE FROM public.gateway_message WHERE id = $1`, gone); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.PurgeOrphanReceipts(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("purged %d, want old-null and old-gone", n)
+	}
+	r, err := s.pool.Query(ctx, `SELECT idempotency_key FROM gw_auto_receipts ORDER BY 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, err := pgx.CollectRows(r, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(left) != "[new-null old-live]" {
+		t.Errorf("left %v", left)
+	}
+}
+
+func TestBackfillDenormFromJSONResumes(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	var ids []int64
+	for _, js := range []string{
+		`{"status": {"csq": 11, "network_type": "LTE-M"}}`,
+		`{"fix": {"lat": 1.5, "lon": 2.5, "tac_lac": 300, "ci": 7}}`,
+		`{"fix": {"lac": 12, "tac_lac": 12, "ci": 8}}`,
+		`{"status": {}}`, // nothing to fill: stays un-backfilled, must not be revisited
+		`{"status": {"csq": 3}}`,
+	} {
+		var id int64
+		if err := s.pool.QueryRow(ctx, `
+			INSERT INTO public.gateway_message (parser, parser_json) VALUES ('test', $1) RETURNING id
+		`, js).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	n, err := s.BackfillDenormFromJSON(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || s.backfillAfter != ids[4] {
+		t.Errorf("processed %d up to %d, want 5 up to %d", n, s.backfillAfter, ids[4])
+	}
+	if n, err := s.BackfillDenormFromJSON(ctx, 2); err != nil || n != 0 {
+		t.Errorf("resume from %d: processed %d, err %v", s.backfillAfter, n, err)
+	}
+
+	var csq, tac, lac *int
+	if err := s.pool.QueryRow(ctx, `SELECT csq FROM public.gateway_message WHERE id = $1`, ids[0]).Scan(&csq); err != nil || deref(csq) != 11 {
+		t.Errorf("row 0 csq %v err %v", deref(csq), err)
+	}
+	if err := s.pool.QueryRow(ctx, `SELECT tac, lac FROM public.gateway_message WHERE id = $1`, ids[1]).Scan(&tac, &lac); err != nil || deref(tac) != 300 || lac != nil {
+		t.Errorf("row 1 tac %v lac %v err %v", deref(tac), deref(lac), err)
+	}
+	if err := s.pool.QueryRow(ctx, `SELECT tac, lac FROM public.gateway_message WHERE id = $1`, ids[2]).Scan(&tac, &lac); err != nil || tac != nil || deref(lac) != 12 {
+		t.Errorf("row 2 tac %v lac %v err %v", deref(tac), deref(lac), err)
+	}
+}
+
+func TestDeleteByMACPrefix(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	for _, mac := range []string{`\xAABB01`, `\xAABB02`, `\xCCDD01`} {
+		if _, err := s.pool.Exec(ctx, `INSERT INTO public.gateway_message (gw_mac) VALUES ($1::bytea)`, mac); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.DeleteByMACPrefix(ctx, nil); err == nil {
+		t.Error("empty prefix accepted")
+	}
+	n, err := s.DeleteByMACPrefix(ctx, []byte{0xAA, 0xBB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleted %d, want 2", n)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func eqPtr[T comparable](a, b *T) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
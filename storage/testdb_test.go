@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testSchema is the subset of the production schema (baseline plus
+// ../migrations) the Store touches.
+const testSchema = `
+CREATE TABLE IF NOT EXISTS public.gateway_message (
+	id                  bigserial PRIMARY KEY,
+	gw_mac              bytea,
+	ts_device           timestamptz,
+	payload_hex         text,
+	raw_json            jsonb,
+	parser              text,
+	parser_json         jsonb,
+	parser_warnings     text[],
+	published_at        timestamptz,
+	latitude            double precision,
+	longitude           double precision,
+	tac                 int,
+	lac                 int,
+	cell_id             bigint,
+	geohash             text,
+	accuracy_m          int,
+	network_type        text,
+	csq                 int,
+	batt_mv             int,
+	axis_x_mg           int,
+	axis_y_mg           int,
+	axis_z_mg           int,
+	acc_status          int,
+	imei                text,
+	iccid               text,
+	temp_c              double precision,
+	humidity            int,
+	boot_count          bigint,
+	uptime_sec          bigint,
+	batt_temp_c         int,
+	rsrp                int,
+	rsrq                int,
+	plmn                text,
+	config_version      int,
+	board_temp_c        double precision,
+	link_quality        int,
+	report_interval_sec int,
+	pending_downlinks   int,
+	motion_events       int,
+	moving_sec          int,
+	power_source        text,
+	charging_state      text,
+	axis_magnitude      double precision,
+	tilt_deg            double precision
+);
+CREATE TABLE IF NOT EXISTS gw_auto_receipts (
+	idempotency_key text NOT NULL UNIQUE,
+	gw_mac          bytea,
+	row_id          bigint,
+	created_at      timestamptz NOT NULL DEFAULT now()
+);
+TRUNCATE public.gateway_message, gw_auto_receipts RESTART IDENTITY;
+`
+
+// testStore connects to STORAGE_TEST_DSN and resets the tables above, so
+// point it at a throwaway database. Skips when unset.
+func testStore(t testing.TB) *Store {
+	t.Helper()
+	dsn := os.Getenv("STORAGE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("STORAGE_TEST_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := pool.Exec(ctx, testSchema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	return newStore(pool, nil)
+}
+
+// insertParsedRow adds one parsed, unpublished row and returns its id.
+func insertParsedRow(t testing.TB, s *Store) int64 {
+	t.Helper()
+	var id int64
+	err := s.pool.QueryRow(context.Background(), `
+		INSERT INTO public.gateway_message (gw_mac, ts_device, payload_hex, parser, parser_json)
+		VALUES ('\xAABBCCDDEEFF'::bytea, now(), '', 'test', '{}')
+		RETURNING id
+	`).Scan(&id)
+	if err != nil {
+		t.Fatalf("insert row: %v", err)
+	}
+	return id
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// querier is the subset shared by *pgxpool.Pool and pgx.Tx, so write paths
+// can run either standalone or inside a Tx.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Tx groups the idempotency receipt and the parse write of one request so
+// they commit together: if we die in between, the receipt rolls back and the
+// client's retry is reprocessed instead of silently deduped.
+type Tx struct {
+	tx pgx.Tx
+}
+
+func (s *Store) Begin(ctx context.Context) (*Tx, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx}, nil
+}
+
+// InsertReceipt records the idempotency key and the row it is processing
+// (nil when the envelope had none); dup is true when it already existed.
+// Assumes gw_auto_receipts.row_id (bigint NULL).
+func (t *Tx) InsertReceipt(ctx context.Context, key string, rowID *int64) (dup bool, err error) {
+	tag, err := t.tx.Exec(ctx, `
+		INSERT INTO gw_auto_receipts (idempotency_key, row_id) VALUES ($1, $2)
+		ON CONFLICT (idempotency_key) DO NOTHING
+	`, key, rowID)
+	if err != nil {
+		return false, err
+	}
+	// If nothing was inserted, it was a duplicate.
+	return tag.RowsAffected() == 0, nil
+}
+
+// InsertReceiptForMAC is InsertReceipt scoped to one gateway, so two devices
+// reusing a key don't dedupe each other. Assumes gw_auto_receipts.gw_mac
+// (bytea) and a unique index on (gw_mac, idempotency_key) in place of the
+// key-only one.
+func (t *Tx) InsertReceiptForMAC(ctx context.Context, gwMAC []byte, key string, rowID *int64) (dup bool, err error) {
+	tag, err := t.tx.Exec(ctx, `
+		INSERT INTO gw_auto_receipts (gw_mac, idempotency_key, row_id) VALUES ($1, $2, $3)
+		ON CONFLICT (gw_mac, idempotency_key) DO NOTHING
+	`, gwMAC, key, rowID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 0, nil
+}
+
+func (t *Tx) UpdateGatewayParsedAndDenormByID(
+	ctx context.Context,
+	id int64,
+	parser string,
+	parsed any,
+	deviceTs time.Time,
+	st *AutoStatus,
+	fx *AutoFix,
+	warnings []string,
+) error {
+	return updateParsedAndDenorm(ctx, t.tx, id, parser, parsed, deviceTs, st, fx, warnings)
+}
+
+// UpdateGatewayParsedByID writes parser + parser_json only, for frames with
+// nothing to denormalize (JSON gateways), so existing columns survive.
+func (t *Tx) UpdateGatewayParsedByID(ctx context.Context, id int64, parser string, parsed any) error {
+	return updateParsed(ctx, t.tx, id, parser, parsed)
+}
+
+// InsertParsed inserts one row (denorm columns included) and returns its id;
+// CopyInsertParsed is the bulk form.
+func (t *Tx) InsertParsed(ctx context.Context, row InsertRow) (int64, error) {
+	vals, err := insertValues(row)
+	if err != nil {
+		return 0, err
+	}
+	marks := make([]string, len(vals))
+	for i := range marks {
+		marks[i] = fmt.Sprintf("$%d", i+1)
+	}
+	var id int64
+	err = t.tx.QueryRow(ctx, `
+		INSERT INTO public.gateway_message (`+strings.Join(copyInsertColumns, ", ")+`)
+		VALUES (`+strings.Join(marks, ", ")+`)
+		RETURNING id
+	`, vals...).Scan(&id)
+	return id, err
+}
+
+// CopyInsertParsed bulk-inserts rows inside the tx, so the batch commits
+// with its receipts. ids[i] is the id of rows[i].
+func (t *Tx) CopyInsertParsed(ctx context.Context, rows []InsertRow) ([]int64, error) {
+	return copyInsertParsed(ctx, t.tx, rows)
+}
+
+// InsertReceipts is InsertReceipt (InsertReceiptForMAC when gwMACs is
+// non-nil) for a batch, with row_id left NULL for SetReceiptRows. dup[i]
+// is true when keys[i] already existed, or repeats an earlier entry.
+func (t *Tx) InsertReceipts(ctx context.Context, gwMACs [][]byte, keys []string) (dup []bool, err error) {
+	var rows pgx.Rows
+	if gwMACs == nil {
+		rows, err = t.tx.Query(ctx, `
+			INSERT INTO gw_auto_receipts (idempotency_key)
+			SELECT k FROM unnest($1::text[]) AS k
+			ON CONFLICT (idempotency_key) DO NOTHING
+			RETURNING ''::bytea, idempotency_key
+		`, keys)
+	} else {
+		rows, err = t.tx.Query(ctx, `
+			INSERT INTO gw_auto_receipts (gw_mac, idempotency_key)
+			SELECT * FROM unnest($1::bytea[], $2::text[])
+			ON CONFLICT (gw_mac, idempotency_key) DO NOTHING
+			RETURNING gw_mac, idempotency_key
+		`, gwMACs, keys)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inserted := map[string]bool{}
+	for rows.Next() {
+		var mac []byte
+		var key string
+		if err := rows.Scan(&mac, &key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		inserted[string(mac)+"\x00"+key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	dup = make([]bool, len(keys))
+	for i, k := range keys {
+		var mac []byte
+		if gwMACs != nil {
+			mac = gwMACs[i]
+		}
+		id := string(mac) + "\x00" + k
+		dup[i] = !inserted[id]
+		delete(inserted, id) // a repeat within the batch is a dup of the first
+	}
+	return dup, nil
+}
+
+// SetReceiptRows points each receipt keys[i] (scoped to gwMACs[i] when
+// gwMACs is non-nil) at ids[i], for rows inserted after their receipt.
+func (t *Tx) SetReceiptRows(ctx context.Context, gwMACs [][]byte, keys []string, ids []int64) error {
+	if gwMACs == nil {
+		_, err := t.tx.Exec(ctx, `
+			UPDATE gw_auto_receipts r SET row_id = v.id
+			FROM unnest($1::text[], $2::bigint[]) AS v(key, id)
+			WHERE r.idempotency_key = v.key
+		`, keys, ids)
+		return err
+	}
+	_, err := t.tx.Exec(ctx, `
+		UPDATE gw_auto_receipts r SET row_id = v.id
+		FROM unnest($1::bytea[], $2::text[], $3::bigint[]) AS v(mac, key, id)
+		WHERE r.gw_mac = v.mac AND r.idempotency_key = v.key
+	`, gwMACs, keys, ids)
+	return err
+}
+
+// MarkPublished is Store.MarkPublished inside the tx, for a sync-ack publish
+// that lands before the commit (the row is still locked by this tx).
+func (t *Tx) MarkPublished(ctx context.Context, id int64) error {
+	return markPublished(ctx, t.tx, id)
+}
+
+// PublishCandidate is an unpublished row claimed by a catch-up worker.
+type PublishCandidate = FrameSummary
+
+// ClaimUnpublished locks up to limit parsed, never-acked rows (oldest
+// first) for this tx. SKIP LOCKED lets concurrent workers each claim a
+// disjoint batch; the claim is released on Commit/Rollback, so a worker
+// publishes, calls MarkPublished per acked row, then commits. Rows it
+// couldn't publish simply stay unpublished for the next claim.
+func (t *Tx) ClaimUnpublished(ctx context.Context, limit int) ([]PublishCandidate, error) {
+	rows, err := t.tx.Query(ctx, `
+        SELECT id, ts_device,
+               COALESCE(parser_json->>'flag', ''),
+               COALESCE(parser, ''),
+               parser_json
+        FROM public.gateway_message
+        WHERE parser IS NOT NULL
+          AND published_at IS NULL
+        ORDER BY id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFrameSummaries(rows)
+}
+
+// ClaimUnpublished is Tx.ClaimUnpublished in its own tx, for workers that
+// don't need anything else in it. done marks the given ids published and
+// commits, releasing the whole claim; on error it rolls back and the rows
+// stay unpublished. Always call done (with nil ids to just release).
+func (s *Store) ClaimUnpublished(ctx context.Context, limit int) (claimed []PublishCandidate, done func(ctx context.Context, published []int64) error, err error) {
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claimed, err = tx.ClaimUnpublished(ctx, limit); err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, nil, err
+	}
+	done = func(ctx context.Context, published []int64) error {
+		defer tx.Rollback(ctx)
+		for _, id := range published {
+			if err := tx.MarkPublished(ctx, id); err != nil {
+				return fmt.Errorf("mark published (id=%d): %w", id, err)
+			}
+		}
+		return tx.Commit(ctx)
+	}
+	return claimed, done, nil
+}
+
+func (t *Tx) Commit(ctx context.Context) error { return t.tx.Commit(ctx) }
+
+// Rollback is a no-op after Commit, so it is safe to defer.
+func (t *Tx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimUnpublishedConcurrentNoOverlap(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	const rows, workers, batch = 40, 4, 5
+	for range rows {
+		insertParsedRow(t, s)
+	}
+
+	var (
+		mu   sync.Mutex
+		seen = map[int64]int{}
+		wg   sync.WaitGroup
+	)
+	// Every worker claims before any commits, so the batches must be
+	// disjoint purely through SKIP LOCKED.
+	claimed := make(chan struct{})
+	var ready sync.WaitGroup
+	ready.Add(workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, done, err := s.ClaimUnpublished(ctx, batch)
+			ready.Done()
+			if err != nil {
+				t.Errorf("claim: %v", err)
+				return
+			}
+			<-claimed
+			ids := make([]int64, 0, len(got))
+			mu.Lock()
+			for _, c := range got {
+				seen[c.ID]++
+				ids = append(ids, c.ID)
+			}
+			mu.Unlock()
+			if err := done(ctx, ids); err != nil {
+				t.Errorf("done: %v", err)
+			}
+		}()
+	}
+	ready.Wait()
+	close(claimed)
+	wg.Wait()
+
+	if len(seen) != workers*batch {
+		t.Errorf("claimed %d distinct rows, want %d", len(seen), workers*batch)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("row %d claimed %d times", id, n)
+		}
+	}
+
+	// The committed claims are published; the rest are still claimable.
+	rest, done, err := s.ClaimUnpublished(ctx, rows)
+	if err != nil {
+		t.Fatalf("claim rest: %v", err)
+	}
+	defer done(ctx, nil)
+	if len(rest) != rows-workers*batch {
+		t.Errorf("left %d unpublished, want %d", len(rest), rows-workers*batch)
+	}
+	for _, c := range rest {
+		if seen[c.ID] > 0 {
+			t.Errorf("row %d still unpublished after done", c.ID)
+		}
+	}
+}
+
+func TestClaimUnpublishedDoneWithoutIDsReleases(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	id := insertParsedRow(t, s)
+
+	got, done, err := s.ClaimUnpublished(ctx, 10)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != id {
+		t.Fatalf("claimed %+v, want row %d", got, id)
+	}
+	if err := done(ctx, nil); err != nil {
+		t.Fatalf("done: %v", err)
+	}
+	again, done, err := s.ClaimUnpublished(ctx, 10)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	defer done(ctx, nil)
+	if len(again) != 1 {
+		t.Errorf("reclaimed %d rows, want the released one", len(again))
+	}
+}
+
+// The #874 guarantee: a receipt only survives if the parse write commits
+// with it, so a failure in between leaves the client's retry reprocessable.
+func TestReceiptRollsBackWhenUpdateFails(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	id := insertParsedRow(t, s)
+
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup, err := tx.InsertReceipt(ctx, "retry-me", &id); err != nil || dup {
+		t.Fatalf("receipt: dup=%v err=%v", dup, err)
+	}
+	// tac is int4: this value can't be written, so the update fails after
+	// the receipt is already in.
+	fx := &AutoFix{HasCell: true, HasTacLac: true, TacLac: 1 << 40}
+	if err := tx.UpdateGatewayParsedAndDenormByID(ctx, id, "test", map[string]any{}, time.Now(), nil, fx, nil); err == nil {
+		t.Fatal("update with an out-of-range tac succeeded")
+	}
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	dup, err := tx.InsertReceipt(ctx, "retry-me", &id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup {
+		t.Error("retry deduped: the failed attempt's receipt survived")
+	}
+}
+
+// A process dying mid-request never commits; the receipt must not leak.
+func TestReceiptRollsBackWhenTxAbandoned(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	id := insertParsedRow(t, s)
+
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgxTx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Tx{tx: pgxTx}
+	if _, err := tx.InsertReceipt(ctx, "crash", &id); err != nil {
+		t.Fatal(err)
+	}
+	// The "crash": the connection goes away with the tx still open.
+	if err := conn.Conn().Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn.Release()
+
+	var n int
+	if err := s.pool.QueryRow(ctx, `SELECT count(*) FROM gw_auto_receipts WHERE idempotency_key = 'crash'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d receipts left by the abandoned tx", n)
+	}
+}
+
+func TestInsertReceiptDedupes(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	for i, want := range []bool{false, true} {
+		dup, err := tx.InsertReceipt(ctx, "k", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != want {
+			t.Errorf("insert %d: dup=%v, want %v", i+1, dup, want)
+		}
+	}
+}
+
+func TestInsertReceiptsDedupes(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.InsertReceipt(ctx, "old", nil); err != nil {
+		t.Fatal(err)
+	}
+	dup, err := tx.InsertReceipts(ctx, nil, []string{"a", "old", "b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{false, true, false, true}; !slices.Equal(dup, want) {
+		t.Errorf("dup = %v, want %v", dup, want)
+	}
+}
+
+// TestInsertReceiptForMACScopesKey swaps the key-only constraint for the
+// per-MAC index inside the test tx (rolled back, so other tests keep the
+// global one), as IDEMPOTENCY_SCOPE=gw_mac deployments do by hand.
+func TestInsertReceiptForMACScopesKey(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.tx.Exec(ctx, `
+		ALTER TABLE gw_auto_receipts DROP CONSTRAINT gw_auto_receipts_idempotency_key_key;
+		CREATE UNIQUE INDEX gw_auto_receipts_mac_key ON gw_auto_receipts (gw_mac, idempotency_key);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	macA, macB := []byte{0xAA, 0, 0, 0, 0, 1}, []byte{0xBB, 0, 0, 0, 0, 2}
+	for i, step := range []struct {
+		mac  []byte
+		want bool
+	}{{macA, false}, {macB, false}, {macA, true}, {macB, true}} {
+		dup, err := tx.InsertReceiptForMAC(ctx, step.mac, "shared", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != step.want {
+			t.Errorf("insert %d (%X): dup=%v, want %v", i+1, step.mac, dup, step.want)
+		}
+	}
+	dup, err := tx.InsertReceipts(ctx, [][]byte{macA, macB, []byte{0xCC, 0, 0, 0, 0, 3}}, []string{"shared", "shared", "shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, true, false}; !slices.Equal(dup, want) {
+		t.Errorf("batch dup = %v, want %v", dup, want)
+	}
+}